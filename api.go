@@ -1,60 +1,418 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
 type APIServer struct {
-	listenAddr string
-	store      Storage
+	config  ServerConfig
+	store   Storage
+	locator Locator
+	mailer  Mailer
+	// links are the frontend pages emailed links point to
+	links LinksConfig
+	// queryTimeout bounds the database work of each request, if set
+	queryTimeout time.Duration
+	// tasks are the background tasks still running, see background
+	tasks sync.WaitGroup
 }
 
-func NewAPIServer(listenAddr string, store Storage) *APIServer {
+func NewAPIServer(config ServerConfig, store Storage) *APIServer {
 	return &APIServer{
-		listenAddr: listenAddr,
-		store:      store,
+		config:  config,
+		store:   store,
+		locator: algerianCities,
+		mailer:  logMailer{},
+		links:   DefaultAppConfig().Links,
 	}
 }
 
-func (s *APIServer) Run() {
+// Run serves the API until ctx is done, then stops accepting connections
+// and waits up to the shutdown timeout for requests in flight to finish.
+// It returns nil once they have, and any other error that stopped the
+// server.
+func (s *APIServer) Run(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              s.config.Addr,
+		Handler:           withQueryTimeout(s.routes(), s.queryTimeout),
+		ReadTimeout:       s.config.ReadTimeout,
+		ReadHeaderTimeout: s.config.ReadHeaderTimeout,
+		WriteTimeout:      s.config.WriteTimeout,
+		IdleTimeout:       s.config.IdleTimeout,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Println("JSON API server listening on", s.config.Addr)
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down, waiting for requests in flight")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down: %w", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.tasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-shutdownCtx.Done():
+		return errors.New("shutting down: background tasks still running")
+	}
+}
+
+// backgroundTimeout bounds the work of a background task
+const backgroundTimeout = time.Minute
+
+// background runs f after the request of ctx, whose values it keeps but not
+// its cancellation. Run waits for background tasks before returning.
+func (s *APIServer) background(ctx context.Context, f func(ctx context.Context)) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), backgroundTimeout)
+	s.tasks.Add(1)
+	go func() {
+		defer s.tasks.Done()
+		defer cancel()
+		f(ctx)
+	}()
+}
+
+// withQueryTimeout cancels the context of requests, and so the queries
+// made with it, after d or as soon as the client goes away
+func withQueryTimeout(next http.Handler, d time.Duration) http.Handler {
+	if d <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// routes builds the router serving the API, separately from Run so it can
+// be mounted on an httptest server
+func (s *APIServer) routes() *mux.Router {
 	router := mux.NewRouter()
 
-	router.HandleFunc("/CreateCommand", corsMiddleware(makeHTTPHandleFunc(s.handleCreateCommand)))
-	router.HandleFunc("/GetCommands", makeHTTPHandleFunc(s.handleGetCommands))
-	router.HandleFunc("/CreateWorker", corsMiddleware(makeHTTPHandleFunc(s.handleCreateWorker)))
-	router.HandleFunc("/GetWorkers", makeHTTPHandleFunc(s.handleGetWorkers))
+	// Who may call the v1 routes having a legacy alias, for both to be
+	// guarded alike
+	var (
+		listCommands      = access{ScopeCommandsRead, []Role{RoleAdmin, RoleDispatcher}}
+		updateCommand     = access{ScopeCommandsWrite, []Role{RoleAdmin, RoleDispatcher}}
+		deleteCommand     = access{"", []Role{RoleAdmin}}
+		readCommandStatus = access{ScopeCommandsRead, []Role{RoleAdmin, RoleDispatcher, RoleWorker}}
+		listWorkers       = access{ScopeWorkersRead, []Role{RoleAdmin, RoleDispatcher}}
+		readWorker        = access{ScopeWorkersRead, []Role{RoleAdmin, RoleDispatcher, RoleWorker}}
+		updateWorker      = access{ScopeWorkersWrite, []Role{RoleAdmin, RoleDispatcher, RoleWorker}}
+	)
+
+	v1 := router.PathPrefix(apiV1).Subrouter()
+	handleResource(v1, "/commands", methodHandlers{
+		http.MethodGet:  s.guard(s.handleGetCommands, listCommands),
+		http.MethodPost: public(s.handleCreateCommand),
+	})
+	handleResource(v1, "/commands/{id}", methodHandlers{
+		http.MethodGet:    s.protected(s.can(ActionRead, ResourceCommand, s.handleGetCommand), ScopeCommandsRead, RoleAdmin, RoleDispatcher, RoleWorker),
+		http.MethodPatch:  s.guard(s.can(ActionUpdate, ResourceCommand, s.handlePatchCommand), updateCommand),
+		http.MethodDelete: s.guard(s.can(ActionDelete, ResourceCommand, s.handleDeleteCommand), deleteCommand),
+	})
+	handleResource(v1, "/commands/{id}/transition", methodHandlers{
+		http.MethodPost: s.guard(s.can(ActionUpdate, ResourceCommand, s.handleTransitionCommand), updateCommand),
+	})
+	handleResource(v1, "/commands/{id}/history", methodHandlers{
+		http.MethodGet: s.guard(s.can(ActionRead, ResourceCommand, s.handleGetCommandHistory), readCommandStatus),
+	})
+	handleResource(v1, "/commands/{id}/assignments", methodHandlers{
+		http.MethodGet:  s.protected(s.can(ActionRead, ResourceCrew, s.handleGetAssignments), ScopeCommandsRead, RoleAdmin, RoleDispatcher, RoleWorker),
+		http.MethodPost: s.protected(s.can(ActionUpdate, ResourceCrew, s.handleAssignWorker), ScopeCommandsWrite, RoleAdmin, RoleDispatcher),
+	})
+	handleResource(v1, "/commands/{id}/assignments/{workerId}", methodHandlers{
+		http.MethodDelete: s.protected(s.can(ActionUpdate, ResourceCrew, s.handleUnassignWorker), ScopeCommandsWrite, RoleAdmin, RoleDispatcher),
+	})
+	handleResource(v1, "/me/jobs", methodHandlers{
+		http.MethodGet: s.protected(s.handleGetMyJobs, "", RoleAdmin, RoleDispatcher, RoleWorker),
+	})
+	handleResource(v1, "/me/availability", methodHandlers{
+		http.MethodGet: s.protected(s.handleGetMyAvailability, "", RoleAdmin, RoleDispatcher, RoleWorker),
+		http.MethodPut: s.protected(s.handlePutMyAvailability, "", RoleAdmin, RoleDispatcher, RoleWorker),
+	})
+	handleResource(v1, "/me/time-off", methodHandlers{
+		http.MethodGet:  s.protected(s.handleGetMyTimeOff, "", RoleAdmin, RoleDispatcher, RoleWorker),
+		http.MethodPost: s.protected(s.handleCreateTimeOff, "", RoleAdmin, RoleDispatcher, RoleWorker),
+	})
+	handleResource(v1, "/me/2fa", methodHandlers{
+		http.MethodGet:    s.twoFactorSetup(s.handleGetTwoFactor),
+		http.MethodDelete: s.twoFactorSetup(s.handleDisableTwoFactor),
+	})
+	handleResource(v1, "/me/2fa/enroll", methodHandlers{
+		http.MethodPost: s.twoFactorSetup(s.handleEnrollTwoFactor),
+	})
+	handleResource(v1, "/me/2fa/confirm", methodHandlers{
+		http.MethodPost: s.twoFactorSetup(s.handleConfirmTwoFactor),
+	})
+	handleResource(v1, "/me/2fa/recovery-codes", methodHandlers{
+		http.MethodPost: s.twoFactorSetup(s.handleRegenerateRecoveryCodes),
+	})
+	handleResource(v1, "/time-off", methodHandlers{
+		http.MethodGet: s.protected(s.handleGetTimeOff, ScopeWorkersRead, RoleAdmin, RoleDispatcher),
+	})
+	handleResource(v1, "/time-off/{id}", methodHandlers{
+		http.MethodPatch: s.protected(s.handleReviewTimeOff, "", RoleAdmin),
+	})
+	handleResource(v1, "/applications", methodHandlers{
+		http.MethodGet: s.protected(s.handleGetApplications, ScopeWorkersRead, RoleAdmin),
+	})
+	handleResource(v1, "/applications/{id}", methodHandlers{
+		http.MethodGet: s.protected(s.handleGetApplication, ScopeWorkersRead, RoleAdmin),
+	})
+	handleResource(v1, "/applications/{id}/transition", methodHandlers{
+		http.MethodPost: s.protected(s.handleTransitionApplication, "", RoleAdmin),
+	})
+	handleResource(v1, "/applications/{id}/notes", methodHandlers{
+		http.MethodPost: s.protected(s.handleCreateApplicationNote, "", RoleAdmin),
+	})
+	handleResource(v1, "/applications/{id}/invitation", methodHandlers{
+		http.MethodPost: s.protected(s.handleInviteWorker, "", RoleAdmin),
+	})
+	handleResource(v1, "/activate", methodHandlers{
+		http.MethodPost: public(s.handleActivateAccount),
+	})
+	handleResource(v1, "/quotes", methodHandlers{
+		http.MethodPost: public(s.handleCreateQuote),
+	})
+	handleResource(v1, "/rate-card", methodHandlers{
+		http.MethodGet: s.protected(s.handleGetRateCard, "", RoleAdmin, RoleDispatcher),
+		http.MethodPut: s.protected(s.handlePutRateCard, "", RoleAdmin),
+	})
+	handleResource(v1, "/workers", methodHandlers{
+		http.MethodGet:  s.guard(s.handleGetWorkers, listWorkers),
+		http.MethodPost: public(s.handleCreateWorker),
+	})
+	// Before /workers/{id}, which would take "available" for an id
+	handleResource(v1, "/workers/available", methodHandlers{
+		http.MethodGet: s.protected(s.handleGetAvailableWorkers, ScopeWorkersRead, RoleAdmin, RoleDispatcher),
+	})
+	handleResource(v1, "/workers/{id}", methodHandlers{
+		http.MethodGet:    s.guard(s.can(ActionRead, ResourceAccount, s.handleGetWorkerByID), readWorker),
+		http.MethodPatch:  s.guard(s.can(ActionUpdate, ResourceAccount, s.handlePatchWorker), updateWorker),
+		http.MethodDelete: s.protected(s.can(ActionDelete, ResourceAccount, s.handleDeleteWorker), "", RoleAdmin),
+	})
+	handleResource(v1, "/workers/{id}/availability", methodHandlers{
+		http.MethodGet: s.protected(s.can(ActionRead, ResourceAccount, s.handleGetWorkerAvailability), ScopeWorkersRead, RoleAdmin, RoleDispatcher),
+	})
+
+	router.HandleFunc("/.well-known/jwks.json", makeHTTPHandleFunc(s.handleJWKS))
 	router.HandleFunc("/Regestration", corsMiddleware(makeHTTPHandleFunc(s.handleRegestration)))
-	router.HandleFunc("/account/{id}", corsMiddleware(withJWTAuth(makeHTTPHandleFunc(s.handleGetWorkerByID), s.store)))
-	router.HandleFunc("/UpdateCommand", corsMiddleware(makeHTTPHandleFunc(s.handleUpdateCommand)))
-	router.HandleFunc("/UpdateWorker", corsMiddleware(makeHTTPHandleFunc(s.handleUpdateWorker)))
-	router.HandleFunc("/DeleteCommand", corsMiddleware(makeHTTPHandleFunc(s.handleDeleteCommand)))
-	router.HandleFunc("/DeleteDataBaseTables", corsMiddleware(makeHTTPHandleFunc(s.handleDeleteDBTables)))
-	log.Println("JSON API server running on port: ", s.listenAddr)
-
-	http.ListenAndServe(s.listenAddr, router)
+	router.HandleFunc("/auth/refresh", corsMiddleware(makeHTTPHandleFunc(s.handleRefresh)))
+	router.HandleFunc("/auth/logout", corsMiddleware(makeHTTPHandleFunc(s.handleLogout)))
+	router.HandleFunc("/auth/2fa", corsMiddleware(makeHTTPHandleFunc(s.handleTwoFactorLogin))).Methods("POST", "OPTIONS")
+	router.HandleFunc("/auth/forgot", corsMiddleware(makeHTTPHandleFunc(s.handleForgotPassword))).Methods("POST", "OPTIONS")
+	router.HandleFunc("/auth/reset", corsMiddleware(makeHTTPHandleFunc(s.handleResetPassword))).Methods("POST", "OPTIONS")
+	router.HandleFunc("/admin/workers/{id}/sessions/revoke", corsMiddleware(withJWTAuth(withRoles(makeHTTPHandleFunc(s.handleRevokeWorkerSessions), RoleAdmin), s.store)))
+	router.HandleFunc("/admin/api-keys", corsMiddleware(withJWTAuth(withRoles(makeHTTPHandleFunc(s.handleCreateAPIKey), RoleAdmin), s.store))).Methods("POST", "OPTIONS")
+	router.HandleFunc("/admin/api-keys", corsMiddleware(withJWTAuth(withRoles(makeHTTPHandleFunc(s.handleGetAPIKeys), RoleAdmin), s.store))).Methods("GET")
+	router.HandleFunc("/admin/workers/{id}/2fa", corsMiddleware(withJWTAuth(withRoles(makeHTTPHandleFunc(s.handleResetTwoFactor), RoleAdmin), s.store))).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/admin/lockouts", corsMiddleware(withJWTAuth(withRoles(makeHTTPHandleFunc(s.handleGetLoginThrottles), RoleAdmin), s.store))).Methods("GET")
+	router.HandleFunc("/admin/lockouts/{scope}/{key}", corsMiddleware(withJWTAuth(withRoles(makeHTTPHandleFunc(s.handleClearLoginThrottle), RoleAdmin), s.store))).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/admin/api-keys/{id}", corsMiddleware(withJWTAuth(withRoles(makeHTTPHandleFunc(s.handleRevokeAPIKey), RoleAdmin), s.store))).Methods("DELETE", "OPTIONS")
+
+	// Legacy routes, served by the v1 handlers until the frontend moves over
+	router.HandleFunc("/CreateCommand", deprecated(public(s.handleCreateCommand), "/commands"))
+	router.HandleFunc("/GetCommands", deprecated(s.guard(s.handleGetCommands, listCommands), "/commands"))
+	router.HandleFunc("/UpdateCommand", deprecated(s.guard(fromLegacyBody(s.can(ActionUpdate, ResourceCommand, s.handlePatchCommand), isAcceptedAsStatus, "status"), updateCommand), "/commands/{id}"))
+	router.HandleFunc("/DeleteCommand", deprecated(s.guard(fromLegacyBody(s.can(ActionDelete, ResourceCommand, s.handleDeleteCommand), nil), deleteCommand), "/commands/{id}"))
+	router.HandleFunc("/commands/{id}/transition", deprecated(s.guard(s.can(ActionUpdate, ResourceCommand, s.handleTransitionCommand), updateCommand), "/commands/{id}/transition"))
+	router.HandleFunc("/commands/{id}/history", deprecated(s.guard(s.can(ActionRead, ResourceCommand, s.handleGetCommandHistory), readCommandStatus), "/commands/{id}/history"))
+	router.HandleFunc("/CreateWorker", deprecated(public(s.handleCreateWorker), "/workers"))
+	router.HandleFunc("/GetWorkers", deprecated(s.guard(s.handleGetWorkers, listWorkers), "/workers"))
+	router.HandleFunc("/account/{id}", deprecated(s.guard(s.can(ActionRead, ResourceAccount, s.handleGetWorkerByID), readWorker), "/workers/{id}"))
+	router.HandleFunc("/UpdateWorker", deprecated(s.guard(fromLegacyBody(s.can(ActionUpdate, ResourceAccount, s.handlePatchWorker), nil, "isaccepted"), updateWorker), "/workers/{id}"))
+
+	return router
+}
+
+// apiV1 prefixes the routes of the resource API
+const apiV1 = "/api/v1"
+
+// public serves f to anyone
+func public(f apiFunc) http.HandlerFunc {
+	return corsMiddleware(makeHTTPHandleFunc(f))
 }
 
-func enableCors(w *http.ResponseWriter) {
-	(*w).Header().Set("Access-Control-Allow-Origin", "*") // Or your frontend origin
-	(*w).Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT")
-	(*w).Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	(*w).Header().Set("Access-Control-Allow-Credentials", "true")
+// protected serves f to accounts having one of roles, or API keys granted
+// scope
+func (s *APIServer) protected(f apiFunc, scope Scope, roles ...Role) http.HandlerFunc {
+	return corsMiddleware(withJWTAuth(withAccess(makeHTTPHandleFunc(f), scope, roles...), s.store))
 }
 
+// access is who may call a route: accounts having one of roles, or API
+// keys granted scope
+type access struct {
+	scope Scope
+	roles []Role
+}
+
+// guard serves f to the callers a allows, see protected
+func (s *APIServer) guard(f apiFunc, a access) http.HandlerFunc {
+	return s.protected(f, a.scope, a.roles...)
+}
+
+type methodHandlers map[string]http.HandlerFunc
+
+// handleResource routes each method of path to its handler. Other methods
+// get a 405 listing the allowed ones, except CORS preflights.
+func handleResource(router *mux.Router, path string, handlers methodHandlers) {
+	allowed := []string{http.MethodOptions}
+	for method, h := range handlers {
+		router.HandleFunc(path, h).Methods(method)
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+
+	router.HandleFunc(path, corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, r, ErrMethodNotAllowed)
+	}))
+}
+
+// deprecated marks the responses of a legacy route, pointing clients to
+// its successor under apiV1
+func deprecated(h http.HandlerFunc, successor string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", apiV1, successor))
+		h(w, r)
+	}
+}
+
+// legacyConversion rewrites the body of a legacy request into what the
+// handler of its successor expects, before fromLegacyBody picks the fields
+type legacyConversion func(body map[string]json.RawMessage) error
+
+// fromLegacyBody adapts a handler taking the resource id from the path to
+// a legacy route taking it in the json body. The body is converted, if
+// convert isn't nil, then only fields are passed on, since legacy clients
+// send whole objects of which the route only ever used those.
+func fromLegacyBody(f apiFunc, convert legacyConversion, fields ...string) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		body := map[string]json.RawMessage{}
+		if err := decodeJSON(r, &body); err != nil {
+			return err
+		}
+
+		var id string
+		if err := json.Unmarshal(body["id"], &id); err != nil {
+			return ErrMalformedBody.withCause(err)
+		}
+		if convert != nil {
+			if err := convert(body); err != nil {
+				return err
+			}
+		}
+
+		passed := map[string]json.RawMessage{}
+		for _, field := range fields {
+			if v, ok := body[field]; ok {
+				passed[field] = v
+			}
+		}
+		b, err := json.Marshal(passed)
+		if err != nil {
+			return err
+		}
+
+		r = mux.SetURLVars(r, map[string]string{"id": id})
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		return f(w, r)
+	}
+}
+
+// isAcceptedAsStatus turns the isaccepted of a legacy command, a boolean
+// or the strings migration 0003 read it from, into the status it stands
+// for. An explicit status wins.
+func isAcceptedAsStatus(body map[string]json.RawMessage) error {
+	raw, ok := body["isaccepted"]
+	if _, hasStatus := body["status"]; !ok || hasStatus {
+		return nil
+	}
+
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return ErrMalformedBody.withCause(err)
+	}
+	var status CommandStatus
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case bool:
+		status = StatusRejected
+		if v {
+			status = StatusAccepted
+		}
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "":
+			return nil
+		case "true", "t", "yes", "1", "accepted":
+			status = StatusAccepted
+		case "false", "f", "no", "0", "rejected", "refused":
+			status = StatusRejected
+		}
+	}
+	if status == "" {
+		return &ValidationError{Fields: []FieldError{{Field: "isaccepted", Code: CodeInvalidValue, Message: "must be true or false"}}}
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	body["status"] = b
+	return nil
+}
+
+// allowedOrigins are the origins corsMiddleware lets browsers call the API
+// from, * allowing any. main sets them from the configuration.
+var allowedOrigins = []string{"*"}
+
 func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Allowed Origin
-		w.Header().Set("Access-Control-Allow-Origin", "*") // Change this to your frontend origin
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); contains(allowedOrigins, "*") {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if contains(allowedOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
 		// Allowed Methods
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, PATCH, DELETE")
 		// Allowed Headers
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
@@ -69,36 +427,44 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func (s *APIServer) handleDeleteDBTables(w http.ResponseWriter, r *http.Request) error {
-	err := s.store.DropAllTables()
-	if err != nil {
+func (s *APIServer) handleCreateCommand(w http.ResponseWriter, r *http.Request) error {
+	req := new(CreateCommandRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
 		return err
 	}
 
-	return WriteJSON(w, http.StatusAccepted, "TABLES DELETED")
-}
-
-func (s *APIServer) handleCreateCommand(w http.ResponseWriter, r *http.Request) error {
-	req := new(CreateCommandRequest)
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+	card, err := s.store.GetRateCard(r.Context())
+	if err != nil {
+		return err
+	}
+	quote, err := computeQuote(card, s.locator, &req.QuoteRequest)
+	if err != nil {
 		return err
 	}
 
-	command, err := NewCommand(req.FullName, req.Number, req.Flor, req.Itemtype, req.Service, req.Workers, req.Start, req.Distination, req.Prix, req.IsAccepted)
+	command, err := NewCommand(req.FullName, req.Number, req.Flor, req.Elevator, req.Itemtype, req.Service, req.Workers, req.Start, req.Distination, req.MoveDate, quote.Total)
 	if err != nil {
 		return err
 	}
-	if err := s.store.CreateCommand(command); err != nil {
+	command.Quote = quote
+	if err := s.store.CreateCommand(r.Context(), command); err != nil {
 		return err
 	}
 
-	return WriteJSON(w, http.StatusOK, command)
+	w.Header().Set("Location", apiV1+"/commands/"+command.ID)
+	return WriteJSON(w, http.StatusCreated, command)
 }
 
 func (s *APIServer) handleGetCommands(w http.ResponseWriter, r *http.Request) error {
-	enableCors(&w)
+	q, err := ParseCommandQuery(r.URL.Query())
+	if err != nil {
+		return err
+	}
 
-	commands, err := s.store.GetCommands()
+	commands, err := s.store.GetCommands(r.Context(), q)
 	if err != nil {
 		return err
 	}
@@ -109,130 +475,256 @@ func (s *APIServer) handleGetCommands(w http.ResponseWriter, r *http.Request) er
 func (s *APIServer) handleCreateWorker(w http.ResponseWriter, r *http.Request) error {
 
 	req := new(CreateWorkerRequest)
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
 		return err
 	}
 
 	//get account
-	err := s.store.CreateWorker(
-		&Worker{
-			FullName:   req.FullName,
-			Number:     req.Number,
-			Email:      req.Email,
-			Password:   req.Password,
-			Position:   req.Position,
-			Experience: req.Experience,
-			Message:    req.Message,
-			IsAccepted: req.IsAccepted,
-		})
-	return err
+	worker := &Worker{
+		FullName:   req.FullName,
+		Number:     req.Number,
+		Email:      req.Email,
+		Position:   req.Position,
+		Experience: req.Experience,
+		Message:    req.Message,
+		Role:       RoleWorker,
+	}
+	// An application has no credentials until the worker is hired and
+	// activates their account
+	if err := s.store.CreateWorker(r.Context(), worker); err != nil {
+		return err
+	}
+
+	w.Header().Set("Location", apiV1+"/workers/"+worker.ID)
+	return WriteJSON(w, http.StatusCreated, newWorkerSelf(worker))
 }
 
 func (s *APIServer) handleGetWorkers(w http.ResponseWriter, r *http.Request) error {
-	workers, err := s.store.GetWorkers()
+	q, err := ParseWorkerQuery(r.URL.Query())
 	if err != nil {
 		return err
 	}
 
-	enableCors(&w)
+	workers, err := s.store.GetWorkers(r.Context(), q)
+	if err != nil {
+		return err
+	}
 
-	return WriteJSON(w, http.StatusOK, workers)
+	return WriteJSON(w, http.StatusOK, workerPageView(r, workers))
 }
 func (s *APIServer) handleGetWorkerByID(w http.ResponseWriter, r *http.Request) error {
-
-	fmt.Println("get to handleGetWorkerByID ")
 	id, err := getID(r)
 	if err != nil {
 		return err
 	}
 
-	account, err := s.store.GetAccountByID(id)
+	account, err := s.store.GetAccountByID(r.Context(), id)
 	if err != nil {
 		return err
 	}
 
-	return WriteJSON(w, http.StatusOK, account)
+	return WriteJSON(w, http.StatusOK, workerView(r, account))
 
 }
 
 func (s *APIServer) handleRegestration(w http.ResponseWriter, r *http.Request) error {
-	enableCors(&w)
 	req := new(LoginRequest)
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
 		return err
 	}
 
-	if req.Email == "Krixo" || req.Password == "Nasro1234" {
-		return WriteJSON(w, http.StatusOK, "Welcome Admin")
+	// Unknown emails are throttled and checked like known ones, to look the
+	// same to the client
+	attempt, err := s.reserveLoginAttempt(r.Context(), w, s.loginKeys(r, req.Email))
+	if err != nil {
+		return err
 	}
 
-	worker, err := s.store.Register(req.Password, req.Email)
+	worker, err := s.store.Register(r.Context(), req.Password, req.Email)
+	if errors.Is(err, ErrInvalidCredentials) {
+		s.loginFailed(attempt)
+		return err
+	}
 	if err != nil {
-		WriteJSON(w, http.StatusNotAcceptable, err)
-		fmt.Println("PREBLEME FROM REGISTRATION ///////// WORKER :", worker)
+		s.releaseLoginAttempt(r.Context(), attempt)
+		return err
+	}
+	s.loginSucceeded(r.Context(), attempt)
 
+	if !worker.IsAccepted {
+		return ErrAccountInactive
 	}
 
-	token, err := createJWT(worker)
+	// With two-factor authentication, the password only earns the right
+	// to send a code to /auth/2fa
+	twoFactor, err := s.twoFactorEnabled(r.Context(), worker.ID)
 	if err != nil {
 		return err
 	}
+	if twoFactor {
+		token, err := twoFactorToken(worker)
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, &TwoFactorChallenge{TwoFactorRequired: true, Token: token})
+	}
 
-	// resp := LoginResponse{
-	// 	Token: token,
-	// 	ID:    worker.ID,
-	// }
-	http.SetCookie(w, &http.Cookie{
-		Name:     "x-jwt-token",
-		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour),
-		HttpOnly: true, // Prevent JavaScript access
-		Secure:   true, // Only send over HTTPS
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	})
+	if _, err := s.startSession(r.Context(), w, worker); err != nil {
+		return err
+	}
 
-	return WriteJSON(w, http.StatusOK, worker)
+	return WriteJSON(w, http.StatusOK, newWorkerSelf(worker))
 }
 
-func (s *APIServer) handleUpdateCommand(w http.ResponseWriter, r *http.Request) error {
-	req := new(Command)
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+func (s *APIServer) handleGetCommand(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
 		return err
 	}
-	err := s.store.UpdateCommand(req)
+
+	command, err := s.store.GetCommandByID(r.Context(), id)
 	if err != nil {
-		return WriteJSON(w, http.StatusResetContent, err)
+		return err
 	}
-	return WriteJSON(w, http.StatusAccepted, "Commend Updates Corectly")
+
+	return WriteJSON(w, http.StatusOK, command)
 }
 
-func (s *APIServer) handleUpdateWorker(w http.ResponseWriter, r *http.Request) error {
-	req := new(Worker)
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+func (s *APIServer) handlePatchCommand(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	patch := new(CommandPatch)
+	if err := decodeJSON(r, patch); err != nil {
 		return err
 	}
-	err := s.store.UpdateWorker(req)
+	if err := Validate(patch); err != nil {
+		return err
+	}
+
+	command, err := s.store.PatchCommand(r.Context(), id, patch, currentUserID(r))
 	if err != nil {
-		return WriteJSON(w, http.StatusResetContent, err)
+		return err
 	}
-	return WriteJSON(w, http.StatusAccepted, "Worker Updates Corectly")
+
+	return WriteJSON(w, http.StatusOK, command)
 }
 
-func (s *APIServer) handleDeleteCommand(w http.ResponseWriter, r *http.Request) error {
-	req := new(Command)
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+func (s *APIServer) handleTransitionCommand(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	req := new(TransitionCommandRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	command, err := s.store.TransitionCommand(r.Context(), id, req.Status, currentUserID(r), req.Note)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, command)
+}
+
+func (s *APIServer) handleGetCommandHistory(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
 		return err
 	}
-	err := s.store.DeleteCommand(req)
+
+	history, err := s.store.GetCommandHistory(r.Context(), id)
 	if err != nil {
-		WriteJSON(w, http.StatusBadRequest, "Command Not Deleted")
+		return err
+	}
 
+	return WriteJSON(w, http.StatusOK, history)
+}
+
+func (s *APIServer) handlePatchWorker(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
 		return err
+	}
 
+	patch := new(WorkerPatch)
+	if err := decodeJSON(r, patch); err != nil {
+		return err
+	}
+	if err := Validate(patch); err != nil {
+		return err
+	}
+
+	if patch.IsAccepted != nil {
+		if err := s.authorize(r, ActionManage, ResourceAccount, id); err != nil {
+			return err
+		}
+	}
+	if patch.IsAccepted != nil && *patch.IsAccepted {
+		// Accepting is reinstating a hired worker; hiring goes through
+		// the application pipeline
+		current, err := s.store.GetAccountByID(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		if current.ApplicationStatus != ApplicationHired {
+			return ErrApplicationNotHired
+		}
+	}
+
+	worker, err := s.store.PatchWorker(r.Context(), id, patch)
+	if err != nil {
+		return err
+	}
+	if patch.IsAccepted != nil && !*patch.IsAccepted {
+		// A deactivated worker is logged out everywhere
+		if err := s.store.RevokeWorkerSessions(r.Context(), id); err != nil {
+			return err
+		}
 	}
-	return WriteJSON(w, http.StatusAccepted, "Command Deleted")
 
+	return WriteJSON(w, http.StatusOK, workerView(r, worker))
+}
+
+func (s *APIServer) handleDeleteWorker(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.DeleteWorker(r.Context(), id); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *APIServer) handleDeleteCommand(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.DeleteCommand(r.Context(), &Command{ID: id}); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
 }
 
 func WriteJSON(w http.ResponseWriter, status int, v any) error {
@@ -242,16 +734,28 @@ func WriteJSON(w http.ResponseWriter, status int, v any) error {
 	return json.NewEncoder(w).Encode(v)
 }
 
-type apiFunc func(http.ResponseWriter, *http.Request) error
+var (
+	ErrMalformedBody    = BadRequest("malformed_body", "request body is not valid JSON")
+	ErrMethodNotAllowed = &Error{Kind: KindMethodNotAllowed, Code: "method_not_allowed", Message: "method not allowed"}
+)
 
-type ApiError struct {
-	Error string `json:"error"`
+// decodeJSON reads the request body into v, reporting a malformed body as
+// a bad request rather than a server error
+func decodeJSON(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return ErrMalformedBody.withCause(err)
+	}
+	return nil
 }
 
+type apiFunc func(http.ResponseWriter, *http.Request) error
+
+// makeHTTPHandleFunc adapts an apiFunc, reporting the error it returns as
+// problem+json with the status its kind maps to
 func makeHTTPHandleFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
-			WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			writeError(w, r, err)
 		}
 	}
 }
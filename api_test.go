@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestLegacyUpdateCommandIsAccepted(t *testing.T) {
+	a := newWorkerAPI(t)
+	dispatcher := a.login(a.createAccount("dispatcher@krixo.test", RoleDispatcher))
+
+	tests := []struct {
+		name       string
+		isaccepted any
+		want       CommandStatus
+		code       int
+	}{
+		{"true", true, StatusAccepted, http.StatusOK},
+		{"false", false, StatusRejected, http.StatusOK},
+		{"true as text", "true", StatusAccepted, http.StatusOK},
+		{"refused as text", "refused", StatusRejected, http.StatusOK},
+		{"empty", "", StatusPending, http.StatusOK},
+		{"null", nil, StatusPending, http.StatusOK},
+		{"unknown", "maybe", StatusPending, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCommand(t, "furniture", 150000)
+			if err := a.store.CreateCommand(context.Background(), c); err != nil {
+				t.Fatal(err)
+			}
+
+			// Legacy clients send the whole command back
+			body := map[string]any{
+				"id":          c.ID,
+				"fullname":    c.FullName,
+				"number":      c.Number,
+				"flor":        "2",
+				"itemtype":    c.Itemtype,
+				"service":     c.Service,
+				"workers":     "2",
+				"start":       c.Start,
+				"distination": c.Distination,
+				"prix":        "1500",
+				"isaccepted":  tt.isaccepted,
+			}
+			resp, got := a.do(http.MethodPost, "/UpdateCommand", dispatcher, body)
+			if resp.StatusCode != tt.code {
+				t.Fatalf("POST /UpdateCommand = %d %s, want %d", resp.StatusCode, got, tt.code)
+			}
+			if resp.Header.Get("Deprecation") == "" {
+				t.Error("POST /UpdateCommand isn't marked deprecated")
+			}
+			if resp.StatusCode == http.StatusOK {
+				command := new(Command)
+				if err := json.Unmarshal([]byte(got), command); err != nil {
+					t.Fatal(err)
+				}
+				if command.Status != tt.want {
+					t.Errorf("response status = %s, want %s", command.Status, tt.want)
+				}
+			}
+
+			stored, err := a.store.GetCommandByID(context.Background(), c.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.want {
+				t.Errorf("stored status = %s, want %s", stored.Status, tt.want)
+			}
+		})
+	}
+}
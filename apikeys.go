@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Scope is a permission granted to an API key
+type Scope string
+
+const (
+	ScopeCommandsRead  Scope = "commands:read"
+	ScopeCommandsWrite Scope = "commands:write"
+	ScopeWorkersRead   Scope = "workers:read"
+	ScopeWorkersWrite  Scope = "workers:write"
+)
+
+// apiKeyPrefix marks API keys, telling them apart from JWTs in the
+// Authorization header
+const apiKeyPrefix = "krx_"
+
+var (
+	ErrInvalidAPIKey = Unauthorized("invalid_api_key", "invalid API key")
+	ErrInvalidScope  = Invalid(CodeInvalidValue, "invalid scope")
+)
+
+// Valid reports whether s is a known scope
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeCommandsRead, ScopeCommandsWrite, ScopeWorkersRead, ScopeWorkersWrite:
+		return true
+	}
+	return false
+}
+
+// APIKey is a long-lived credential for machine clients. The key itself
+// is only shown once, at creation; Prefix identifies it afterwards.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []Scope    `json:"scopes"`
+	CreatedBy  string     `json:"createdby"`
+	CreatedAt  time.Time  `json:"createdat"`
+	LastUsedAt *time.Time `json:"lastusedat"`
+	RevokedAt  *time.Time `json:"revokedat"`
+}
+
+// HasScope reports whether the key was granted scope
+func (k *APIKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string  `json:"name" validate:"required,max=100"`
+	Scopes []Scope `json:"scopes" validate:"required,max=10"`
+}
+
+type CreateAPIKeyResponse struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+// newAPIKey generates a key of the form krx_<prefix>_<secret>
+func newAPIKey() (key, prefix string, err error) {
+	b := make([]byte, 6+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	prefix = hex.EncodeToString(b[:6])
+	key = apiKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(b[6:])
+	return key, prefix, nil
+}
+
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// authenticateAPIKey looks a presented key up by its prefix and checks it
+// against the stored hash
+func authenticateAPIKey(ctx context.Context, s Storage, key string) (*APIKey, error) {
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	apiKey, err := s.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(hashToken(key))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if apiKey.RevokedAt != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if err := s.TouchAPIKey(ctx, apiKey.ID); err != nil {
+		return nil, err
+	}
+
+	return apiKey, nil
+}
+
+// currentAPIKey returns the API key withJWTAuth authenticated the request
+// with, or nil for requests made with a user token
+func currentAPIKey(r *http.Request) *APIKey {
+	key, _ := r.Context().Value("apiKey").(*APIKey)
+	return key
+}
+
+func (s *APIServer) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) error {
+	req := new(CreateAPIKeyRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+	for i, scope := range req.Scopes {
+		if !scope.Valid() {
+			return &ValidationError{Fields: []FieldError{{
+				Field:   fmt.Sprintf("scopes[%d]", i),
+				Code:    CodeInvalidValue,
+				Message: fmt.Sprintf("%s %q", ErrInvalidScope, scope),
+			}}}
+		}
+	}
+
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		return err
+	}
+	apiKey := &APIKey{
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(key),
+		Scopes:    req.Scopes,
+		CreatedBy: currentUserID(r),
+	}
+	if err := s.store.CreateAPIKey(r.Context(), apiKey); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusCreated, CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+}
+
+func (s *APIServer) handleGetAPIKeys(w http.ResponseWriter, r *http.Request) error {
+	keys, err := s.store.GetAPIKeys(r.Context())
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, keys)
+}
+
+func (s *APIServer) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.RevokeAPIKey(r.Context(), id); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ApplicationStatus is where a worker's application is in the hiring
+// pipeline
+type ApplicationStatus string
+
+const (
+	ApplicationSubmitted ApplicationStatus = "submitted"
+	ApplicationScreening ApplicationStatus = "screening"
+	ApplicationInterview ApplicationStatus = "interview"
+	ApplicationHired     ApplicationStatus = "hired"
+	ApplicationRejected  ApplicationStatus = "rejected"
+)
+
+var (
+	ErrInvalidApplicationStatus = Invalid(CodeInvalidValue, "invalid application status")
+	ErrApplicationNotHired      = Conflict("application_not_hired", "only hired workers can be accepted")
+)
+
+// applicationTransitions lists, for each status, the statuses an
+// application may move to. Hired and rejected applications are decided.
+var applicationTransitions = map[ApplicationStatus][]ApplicationStatus{
+	ApplicationSubmitted: {ApplicationScreening, ApplicationRejected},
+	ApplicationScreening: {ApplicationInterview, ApplicationRejected},
+	ApplicationInterview: {ApplicationHired, ApplicationRejected},
+	ApplicationHired:     {},
+	ApplicationRejected:  {},
+}
+
+// Valid reports whether s is a known status
+func (s ApplicationStatus) Valid() bool {
+	_, ok := applicationTransitions[s]
+	return ok
+}
+
+// CanTransition reports whether an application may move from s to next
+func (s ApplicationStatus) CanTransition(next ApplicationStatus) bool {
+	for _, allowed := range applicationTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// checkApplicationTransition returns ErrInvalidApplicationStatus or
+// ErrIllegalTransition when an application can't move from one status to
+// the other
+func checkApplicationTransition(from, to ApplicationStatus) error {
+	if !to.Valid() {
+		return ErrInvalidApplicationStatus
+	}
+	if !from.CanTransition(to) {
+		return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, from, to)
+	}
+	return nil
+}
+
+// initialApplicationStatus is the status a new account starts with:
+// accounts created accepted, like the bootstrap admin, are already hired
+func initialApplicationStatus(w *Worker) ApplicationStatus {
+	if w.ApplicationStatus != "" {
+		return w.ApplicationStatus
+	}
+	if w.IsAccepted {
+		return ApplicationHired
+	}
+	return ApplicationSubmitted
+}
+
+// ApplicationStatusChange is one decision in a worker's application
+type ApplicationStatusChange struct {
+	ID         string            `json:"id"`
+	WorkerID   string            `json:"workerid"`
+	FromStatus ApplicationStatus `json:"fromstatus"`
+	ToStatus   ApplicationStatus `json:"tostatus"`
+	ChangedBy  string            `json:"changedby"`
+	Note       string            `json:"note"`
+	ChangedAt  time.Time         `json:"changedat"`
+}
+
+// ApplicationNote is a reviewer's remark on an application, apart from
+// any decision
+type ApplicationNote struct {
+	ID        string    `json:"id"`
+	WorkerID  string    `json:"workerid"`
+	AuthorID  string    `json:"authorid"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdat"`
+}
+
+// Application is a worker's application with its review trail
+type Application struct {
+	Worker  *WorkerAdminView           `json:"worker"`
+	History []*ApplicationStatusChange `json:"history"`
+	Notes   []*ApplicationNote         `json:"notes"`
+}
+
+type TransitionApplicationRequest struct {
+	Status ApplicationStatus `json:"status" validate:"required,oneof=submitted|screening|interview|hired|rejected"`
+	Note   string            `json:"note" validate:"max=1000"`
+}
+
+type CreateApplicationNoteRequest struct {
+	Body string `json:"body" validate:"required,max=5000"`
+}
+
+// handleGetApplications lists applications, filtered by status, position
+// and the other worker filters
+func (s *APIServer) handleGetApplications(w http.ResponseWriter, r *http.Request) error {
+	q, err := ParseWorkerQuery(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	q.Role = RoleWorker
+
+	workers, err := s.store.GetWorkers(r.Context(), q)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, workerPageView(r, workers))
+}
+
+func (s *APIServer) handleGetApplication(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	worker, err := s.store.GetAccountByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	history, err := s.store.GetApplicationHistory(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	notes, err := s.store.GetApplicationNotes(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, &Application{Worker: newWorkerAdminView(worker), History: history, Notes: notes})
+}
+
+func (s *APIServer) handleTransitionApplication(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	req := new(TransitionApplicationRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	worker, err := s.store.TransitionApplication(r.Context(), id, req.Status, currentUserID(r), req.Note)
+	if err != nil {
+		return err
+	}
+	if worker.ApplicationStatus == ApplicationHired {
+		s.sendInvitation(r.Context(), worker, currentUserID(r))
+	}
+
+	return WriteJSON(w, http.StatusOK, workerView(r, worker))
+}
+
+func (s *APIServer) handleCreateApplicationNote(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	req := new(CreateApplicationNoteRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	note := &ApplicationNote{WorkerID: id, AuthorID: currentUserID(r), Body: req.Body}
+	if err := s.store.AddApplicationNote(r.Context(), note); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusCreated, note)
+}
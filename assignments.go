@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AssignmentRole is the part a worker plays in a command's crew
+type AssignmentRole string
+
+const (
+	AssignmentDriver AssignmentRole = "driver"
+	AssignmentMover  AssignmentRole = "mover"
+	AssignmentLead   AssignmentRole = "lead"
+)
+
+// defaultJobDuration is how long a command keeps its crew busy until
+// staff estimate it
+const defaultJobDuration = 4 * time.Hour
+
+var (
+	ErrWorkerNotAssignable  = Conflict("worker_not_assignable", "only accepted workers can be assigned")
+	ErrCommandNotAssignable = Conflict("command_not_assignable", "only accepted or scheduled commands with a move date can be staffed")
+	ErrAlreadyAssigned      = Conflict("already_assigned", "worker is already assigned to this command")
+	ErrCrewFull             = Conflict("crew_full", "command already has all the workers it asked for")
+	ErrLeadTaken            = Conflict("lead_taken", "command already has a lead")
+	ErrDoubleBooked         = Conflict("double_booked", "worker is already booked on an overlapping job")
+)
+
+// Assignment puts a worker on a command's crew
+type Assignment struct {
+	ID         string         `json:"id"`
+	CommandID  string         `json:"commandid"`
+	WorkerID   string         `json:"workerid"`
+	Role       AssignmentRole `json:"role"`
+	AssignedBy string         `json:"assignedby"`
+	AssignedAt time.Time      `json:"assignedat"`
+}
+
+type AssignWorkerRequest struct {
+	WorkerID string         `json:"workerid" validate:"required,max=36"`
+	Role     AssignmentRole `json:"role" validate:"required,oneof=driver|mover|lead"`
+}
+
+// Job is an assignment with its command, as listed to the assigned worker
+type Job struct {
+	*Assignment
+	Command *Command `json:"command"`
+}
+
+// window is when the command keeps its crew busy
+func (c *Command) window() (start, end time.Time) {
+	start = *c.MoveDate
+	return start, start.Add(time.Duration(c.DurationMinutes) * time.Minute)
+}
+
+// busy reports whether the command still holds its crew; final commands
+// free their workers
+func (c *Command) busy() bool {
+	return len(commandTransitions[c.Status]) > 0
+}
+
+func overlaps(aStart, aEnd, bStart, bEnd time.Time) bool {
+	return aStart.Before(bEnd) && bStart.Before(aEnd)
+}
+
+// checkAssignment applies the staffing rules that don't depend on the
+// worker's other jobs, given the command's current crew
+func checkAssignment(command *Command, worker *Worker, crew []*Assignment, a *Assignment) error {
+	if command.MoveDate == nil || (command.Status != StatusAccepted && command.Status != StatusScheduled) {
+		return ErrCommandNotAssignable
+	}
+	if !worker.IsAccepted {
+		return ErrWorkerNotAssignable
+	}
+	for _, member := range crew {
+		if member.WorkerID == a.WorkerID {
+			return ErrAlreadyAssigned
+		}
+		if member.Role == AssignmentLead && a.Role == AssignmentLead {
+			return ErrLeadTaken
+		}
+	}
+	if len(crew) >= command.Workers {
+		return ErrCrewFull
+	}
+	return nil
+}
+
+// doubleBooked reports the conflict of a worker booked on command
+// otherCommandID over the same time
+func doubleBooked(workerID, otherCommandID string) error {
+	return fmt.Errorf("%w: worker %s is on command %s", ErrDoubleBooked, workerID, otherCommandID)
+}
+
+func (s *APIServer) handleGetAssignments(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.store.GetCommandByID(r.Context(), id); err != nil {
+		return err
+	}
+	assignments, err := s.store.GetAssignments(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, assignments)
+}
+
+func (s *APIServer) handleAssignWorker(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	req := new(AssignWorkerRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	assignment := &Assignment{
+		CommandID:  id,
+		WorkerID:   req.WorkerID,
+		Role:       req.Role,
+		AssignedBy: currentUserID(r),
+	}
+	if err := s.store.AssignWorker(r.Context(), assignment); err != nil {
+		return err
+	}
+
+	w.Header().Set("Location", apiV1+"/commands/"+id+"/assignments/"+assignment.WorkerID)
+	return WriteJSON(w, http.StatusCreated, assignment)
+}
+
+func (s *APIServer) handleUnassignWorker(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.UnassignWorker(r.Context(), id, mux.Vars(r)["workerId"]); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleGetMyJobs lists the jobs of the logged-in worker, soonest first
+func (s *APIServer) handleGetMyJobs(w http.ResponseWriter, r *http.Request) error {
+	jobs, err := s.store.GetWorkerJobs(r.Context(), currentUserID(r))
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, jobs)
+}
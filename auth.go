@@ -1,11 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -26,20 +27,31 @@ var DefaultConfig = Config{
 
 // Validation errors
 var (
-	ErrInvalidEmail      = errors.New("invalid email format")
-	ErrPasswordTooShort  = errors.New("password too short")
-	ErrPasswordTooLong   = errors.New("password too long")
-	ErrPasswordWeak      = errors.New("password too weak")
-	ErrEmailExists       = errors.New("email already exists")
-	ErrInvalidCharacters = errors.New("password contains invalid characters")
-	ErrDatabaseError     = errors.New("database error")
+	ErrInvalidEmail      = Invalid(CodeInvalidEmail, "invalid email format")
+	ErrPasswordTooShort  = Invalid(CodeTooShort, "password too short")
+	ErrPasswordTooLong   = Invalid(CodeTooLong, "password too long")
+	ErrPasswordWeak      = Invalid(CodeWeakPassword, "password too weak")
+	ErrEmailExists       = Conflict("email_exists", "email already exists")
+	ErrInvalidCharacters = Invalid(CodeInvalidCharacters, "password contains invalid characters")
+	ErrDatabaseError     = &Error{Kind: KindInternal, Code: "internal", Message: "database error"}
+
+	ErrInvalidCredentials = Unauthorized("invalid_credentials", "invalid email or password")
 )
 
 // CreateUser validates and creates a new user account
-func (s *PostgresStore) CreateUser(email, password string) (string, error) {
+func (s *PostgresStore) CreateUser(ctx context.Context, email, password string) (string, error) {
+	return createUser(email, password, func(email string) error {
+		return checkEmailExists(ctx, s.db, email)
+	})
+}
+
+// createUser validates the credentials and returns the password hash,
+// using emailExists to enforce uniqueness against the backing store. An
+// empty password is an account without credentials, such as an
+// application, and has no hash.
+func createUser(email, password string, emailExists func(string) error) (string, error) {
 	// Sanitize inputs
-	config := DefaultConfig
-	email = strings.TrimSpace(strings.ToLower(email))
+	email = normalizeEmail(email)
 	password = strings.TrimSpace(password)
 
 	// Validate email format
@@ -48,10 +60,22 @@ func (s *PostgresStore) CreateUser(email, password string) (string, error) {
 	}
 
 	// Check if email already exists
-	if err := checkEmailExists(s.db, email); err != nil {
+	if err := emailExists(email); err != nil {
 		return "", err
 	}
 
+	if password == "" {
+		return "", nil
+	}
+
+	return newPasswordHash(password)
+}
+
+// newPasswordHash checks a password chosen by a user and hashes it
+func newPasswordHash(password string) (string, error) {
+	config := DefaultConfig
+	password = strings.TrimSpace(password)
+
 	// Validate password
 	if err := validatePassword(password, config); err != nil {
 		return "", err
@@ -66,6 +90,12 @@ func (s *PostgresStore) CreateUser(email, password string) (string, error) {
 	return hashedPassword, nil
 }
 
+// normalizeEmail is the form emails are stored and looked up in, so that
+// they're unique whatever their case
+func normalizeEmail(email string) string {
+	return strings.TrimSpace(strings.ToLower(email))
+}
+
 // isValidEmail checks email format using regex
 func isValidEmail(email string) bool {
 	// Basic email regex pattern
@@ -75,11 +105,11 @@ func isValidEmail(email string) bool {
 }
 
 // checkEmailExists verifies if email is already registered
-func checkEmailExists(db *sql.DB, email string) error {
+func checkEmailExists(ctx context.Context, db *sql.DB, email string) error {
 	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM worker WHERE email = $1", email).Scan(&count)
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM worker WHERE email = $1", email).Scan(&count)
 	if err != nil {
-		return ErrDatabaseError
+		return fmt.Errorf("%w: %v", ErrDatabaseError, err)
 	}
 	if count > 0 {
 		return ErrEmailExists
@@ -94,7 +124,6 @@ func validatePassword(password string, config Config) error {
 		return ErrPasswordTooShort
 	}
 	if len(password) > config.MaxPasswordLength {
-		fmt.Println("password len = ", password)
 		return ErrPasswordTooLong
 	}
 
@@ -129,6 +158,35 @@ func validatePassword(password string, config Config) error {
 	return nil
 }
 
+// dummyPasswordHash is checked against when there is no account to check,
+// for logins to unknown emails to take as long as those with a wrong
+// password
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not a password"), DefaultConfig.BcryptCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// authenticate checks password against the worker found by a lookup
+// returning err, with ErrInvalidCredentials whether the account doesn't
+// exist, has no password yet or the password is wrong
+func authenticate(worker *Worker, err error, password string) (*Worker, error) {
+	if KindOf(err) == KindNotFound || (err == nil && worker.Password == "") {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(worker.Password), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return worker, nil
+}
+
 // hashPassword creates a secure password hash
 func hashPassword(password string, cost int) (string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), cost)
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AvailabilitySlot is a weekly period a worker can be booked in, in the
+// time zone moves are scheduled in. Start and End are HH:MM, End being
+// 24:00 for slots that last until midnight.
+type AvailabilitySlot struct {
+	Weekday string `json:"weekday"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+}
+
+type AvailabilityRequest struct {
+	Slots []AvailabilitySlot `json:"slots" validate:"max=100"`
+}
+
+// TimeOffStatus is where a time-off request is in its review
+type TimeOffStatus string
+
+const (
+	TimeOffPending  TimeOffStatus = "pending"
+	TimeOffApproved TimeOffStatus = "approved"
+	TimeOffRejected TimeOffStatus = "rejected"
+)
+
+func (s TimeOffStatus) Valid() bool {
+	switch s {
+	case TimeOffPending, TimeOffApproved, TimeOffRejected:
+		return true
+	}
+	return false
+}
+
+var (
+	ErrTimeOffReviewed = Conflict("time_off_reviewed", "time off has already been reviewed")
+	ErrWorkerOnTimeOff = Conflict("on_time_off", "worker has approved time off during this job")
+)
+
+// TimeOff is a period a worker asks not to be booked in. It only counts
+// once an admin approves it.
+type TimeOff struct {
+	ID         string        `json:"id"`
+	WorkerID   string        `json:"workerid"`
+	Start      time.Time     `json:"start"`
+	End        time.Time     `json:"end"`
+	Reason     string        `json:"reason"`
+	Status     TimeOffStatus `json:"status"`
+	ReviewedBy string        `json:"reviewedby"`
+	ReviewedAt *time.Time    `json:"reviewedat"`
+	CreatedAt  time.Time     `json:"createdat"`
+}
+
+type TimeOffRequest struct {
+	Start  *time.Time `json:"start" validate:"required,future"`
+	End    *time.Time `json:"end" validate:"required,future"`
+	Reason string     `json:"reason" validate:"max=1000"`
+}
+
+type ReviewTimeOffRequest struct {
+	Status TimeOffStatus `json:"status" validate:"required,oneof=approved|rejected"`
+}
+
+// minutes parses the slot's bounds as minutes since midnight
+func (slot AvailabilitySlot) minutes() (start, end int, ok bool) {
+	start, okStart := clockMinutes(slot.Start)
+	end, okEnd := clockMinutes(slot.End)
+	return start, end, okStart && okEnd && start < end
+}
+
+// clockMinutes parses HH:MM, from 00:00 to 24:00
+func clockMinutes(s string) (int, bool) {
+	hh, mm, ok := strings.Cut(s, ":")
+	if !ok || len(hh) != 2 || len(mm) != 2 {
+		return 0, false
+	}
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+	if errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, false
+	}
+	return h*60 + m, true
+}
+
+// validate checks what the validate tags can't express about each slot
+func (req *AvailabilityRequest) validate() error {
+	verr := new(ValidationError)
+	if err := Validate(req); err != nil {
+		verr = err.(*ValidationError)
+	}
+
+	for i, slot := range req.Slots {
+		field := fmt.Sprintf("slots[%d]", i)
+		if _, ok := weekdays[slot.Weekday]; !ok {
+			verr.add(field+".weekday", CodeInvalidValue, "must be a lowercase day name")
+		}
+		if _, _, ok := slot.minutes(); !ok {
+			verr.add(field, CodeInvalidValue, "must run from start to a later end, both HH:MM")
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// validate checks the request's tags and that it ends after it starts
+func (req *TimeOffRequest) validate() error {
+	verr := new(ValidationError)
+	if err := Validate(req); err != nil {
+		verr = err.(*ValidationError)
+	}
+	if req.Start != nil && req.End != nil && !req.End.After(*req.Start) {
+		verr.add("end", CodeInvalidValue, "must be after start")
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// coversWindow reports whether the weekly slots cover every minute from
+// start to end, which may span several days
+func coversWindow(slots []AvailabilitySlot, start, end time.Time) bool {
+	at := start.In(algiersTime)
+	end = end.In(algiersTime)
+
+	for at.Before(end) {
+		midnight := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, algiersTime)
+		minute := int(at.Sub(midnight) / time.Minute)
+
+		// The furthest a slot of the day starting by now reaches, slots
+		// of a day possibly touching or overlapping
+		reach := -1
+		for changed := true; changed; {
+			changed = false
+			for _, slot := range slots {
+				slotStart, slotEnd, ok := slot.minutes()
+				if !ok || weekdays[slot.Weekday] != at.Weekday() {
+					continue
+				}
+				from := minute
+				if reach > from {
+					from = reach
+				}
+				if slotStart <= from && slotEnd > from && slotEnd > reach {
+					reach, changed = slotEnd, true
+				}
+			}
+		}
+		if reach < 0 {
+			return false
+		}
+
+		next := midnight.Add(time.Duration(reach) * time.Minute)
+		if reach < 24*60 && next.Before(end) {
+			return false
+		}
+		at = next
+	}
+	return true
+}
+
+// sortSlots orders slots by day of the week, then time of day
+func sortSlots(slots []AvailabilitySlot) {
+	sort.SliceStable(slots, func(i, j int) bool {
+		a, b := slots[i], slots[j]
+		if weekdays[a.Weekday] != weekdays[b.Weekday] {
+			return weekdays[a.Weekday] < weekdays[b.Weekday]
+		}
+		return a.Start < b.Start
+	})
+}
+
+func (s *APIServer) handleGetMyAvailability(w http.ResponseWriter, r *http.Request) error {
+	slots, err := s.store.GetAvailability(r.Context(), currentUserID(r))
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, AvailabilityRequest{Slots: slots})
+}
+
+// handlePutMyAvailability replaces the logged-in worker's weekly slots
+func (s *APIServer) handlePutMyAvailability(w http.ResponseWriter, r *http.Request) error {
+	req := new(AvailabilityRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+
+	sortSlots(req.Slots)
+	if err := s.store.SetAvailability(r.Context(), currentUserID(r), req.Slots); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, req)
+}
+
+func (s *APIServer) handleGetWorkerAvailability(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.store.GetAccountByID(r.Context(), id); err != nil {
+		return err
+	}
+	slots, err := s.store.GetAvailability(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, AvailabilityRequest{Slots: slots})
+}
+
+func (s *APIServer) handleCreateTimeOff(w http.ResponseWriter, r *http.Request) error {
+	req := new(TimeOffRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+
+	timeOff := &TimeOff{
+		WorkerID: currentUserID(r),
+		Start:    req.Start.UTC(),
+		End:      req.End.UTC(),
+		Reason:   req.Reason,
+		Status:   TimeOffPending,
+	}
+	if err := s.store.CreateTimeOff(r.Context(), timeOff); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusCreated, timeOff)
+}
+
+func (s *APIServer) handleGetMyTimeOff(w http.ResponseWriter, r *http.Request) error {
+	q, err := ParseTimeOffQuery(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	q.WorkerID = currentUserID(r)
+
+	timeOff, err := s.store.GetTimeOff(r.Context(), q)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, timeOff)
+}
+
+func (s *APIServer) handleGetTimeOff(w http.ResponseWriter, r *http.Request) error {
+	q, err := ParseTimeOffQuery(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	timeOff, err := s.store.GetTimeOff(r.Context(), q)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, timeOff)
+}
+
+// handleReviewTimeOff approves or rejects a pending time-off request
+func (s *APIServer) handleReviewTimeOff(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	req := new(ReviewTimeOffRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	timeOff, err := s.store.ReviewTimeOff(r.Context(), id, req.Status, currentUserID(r))
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, timeOff)
+}
+
+// handleGetAvailableWorkers lists the accepted workers free over a time
+// window: available by their weekly slots, without approved time off and
+// not booked on an overlapping job
+func (s *APIServer) handleGetAvailableWorkers(w http.ResponseWriter, r *http.Request) error {
+	q, err := ParseAvailabilityQuery(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	workers, err := s.store.GetAvailableWorkers(r.Context(), q)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, workerViews(r, workers))
+}
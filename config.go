@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AppConfig is every setting the server starts with. Defaults are overridden
+// by the YAML file, if any, then by environment variables.
+type AppConfig struct {
+	// Store is the storage backend: postgres or memory
+	Store    string
+	Server   ServerConfig
+	Database DatabaseConfig
+	CORS     CORSConfig
+	Mail     MailConfig
+	Links    LinksConfig
+	Auth     AuthConfig
+}
+
+type ServerConfig struct {
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long in-flight requests get to finish once
+	// the server is asked to stop
+	ShutdownTimeout time.Duration
+	// TrustProxy is set behind a reverse proxy, whose X-Forwarded-For
+	// header then gives the client's address
+	TrustProxy bool
+}
+
+type DatabaseConfig struct {
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// QueryTimeout bounds the database work of a request, which is
+	// cancelled past it
+	QueryTimeout time.Duration
+}
+
+type CORSConfig struct {
+	// AllowedOrigins are the origins browsers may call the API from, or *
+	// for any
+	AllowedOrigins []string
+}
+
+type MailConfig struct {
+	// Driver delivers mail: smtp, file, or log to write it to the log
+	Driver string
+	From   string
+	// Dir is where the file driver writes messages
+	Dir  string
+	SMTP SMTPConfig
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// LinksConfig are the frontend pages emailed links point to. Their token
+// is added as a query parameter.
+type LinksConfig struct {
+	PasswordReset string
+	Activation    string
+}
+
+type AuthConfig struct {
+	// MFARequiredRoles are the roles that must enable two-factor
+	// authentication
+	MFARequiredRoles []Role
+}
+
+// DefaultAppConfig is the configuration of a local development server
+func DefaultAppConfig() *AppConfig {
+	return &AppConfig{
+		Store: "postgres",
+		Server: ServerConfig{
+			Addr:              "0.0.0.0:3000",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Database: DatabaseConfig{
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			QueryTimeout:    10 * time.Second,
+		},
+		CORS: CORSConfig{AllowedOrigins: []string{"*"}},
+		Mail: MailConfig{
+			Driver: "log",
+			From:   "no-reply@krixo.dz",
+			Dir:    "mail",
+			SMTP:   SMTPConfig{Port: 587},
+		},
+		Links: LinksConfig{
+			PasswordReset: "http://localhost:3000/reset-password",
+			Activation:    "http://localhost:3000/activate",
+		},
+		Auth: AuthConfig{MFARequiredRoles: []Role{RoleAdmin}},
+	}
+}
+
+// setting is a configuration value, named key in the YAML file and env in
+// the environment. Either may be empty.
+type setting struct {
+	key string
+	env string
+	set func(string) error
+}
+
+// settings lists every value of c that can be configured. DB_HOST is the
+// name the DSN used to be read from, and is still honoured.
+func (c *AppConfig) settings() []setting {
+	return []setting{
+		{"store", "STORE", setString(&c.Store)},
+		{"server.addr", "LISTEN_ADDR", setString(&c.Server.Addr)},
+		{"server.read_timeout", "READ_TIMEOUT", setDuration(&c.Server.ReadTimeout)},
+		{"server.read_header_timeout", "READ_HEADER_TIMEOUT", setDuration(&c.Server.ReadHeaderTimeout)},
+		{"server.write_timeout", "WRITE_TIMEOUT", setDuration(&c.Server.WriteTimeout)},
+		{"server.idle_timeout", "IDLE_TIMEOUT", setDuration(&c.Server.IdleTimeout)},
+		{"server.shutdown_timeout", "SHUTDOWN_TIMEOUT", setDuration(&c.Server.ShutdownTimeout)},
+		{"server.trust_proxy", "TRUST_PROXY", setBool(&c.Server.TrustProxy)},
+		{"", "DB_HOST", setString(&c.Database.DSN)},
+		{"database.dsn", "DATABASE_URL", setString(&c.Database.DSN)},
+		{"database.max_open_conns", "DB_MAX_OPEN_CONNS", setInt(&c.Database.MaxOpenConns)},
+		{"database.max_idle_conns", "DB_MAX_IDLE_CONNS", setInt(&c.Database.MaxIdleConns)},
+		{"database.conn_max_lifetime", "DB_CONN_MAX_LIFETIME", setDuration(&c.Database.ConnMaxLifetime)},
+		{"database.conn_max_idle_time", "DB_CONN_MAX_IDLE_TIME", setDuration(&c.Database.ConnMaxIdleTime)},
+		{"database.query_timeout", "DB_QUERY_TIMEOUT", setDuration(&c.Database.QueryTimeout)},
+		{"cors.allowed_origins", "CORS_ALLOWED_ORIGINS", setList(&c.CORS.AllowedOrigins)},
+		{"mail.driver", "MAIL_DRIVER", setString(&c.Mail.Driver)},
+		{"mail.from", "MAIL_FROM", setString(&c.Mail.From)},
+		{"mail.dir", "MAIL_DIR", setString(&c.Mail.Dir)},
+		{"mail.smtp.host", "SMTP_HOST", setString(&c.Mail.SMTP.Host)},
+		{"mail.smtp.port", "SMTP_PORT", setInt(&c.Mail.SMTP.Port)},
+		{"mail.smtp.username", "SMTP_USERNAME", setString(&c.Mail.SMTP.Username)},
+		{"mail.smtp.password", "SMTP_PASSWORD", setString(&c.Mail.SMTP.Password)},
+		{"links.password_reset", "PASSWORD_RESET_URL", setString(&c.Links.PasswordReset)},
+		{"links.activation", "ACTIVATION_URL", setString(&c.Links.Activation)},
+		{"auth.mfa_required_roles", "MFA_REQUIRED_ROLES", setRoles(&c.Auth.MFARequiredRoles)},
+	}
+}
+
+func setString(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		*p = n
+		return nil
+	}
+}
+
+func setBool(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", v)
+		}
+		*p = b
+		return nil
+	}
+}
+
+func setDuration(p *time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 5m", v)
+		}
+		*p = d
+		return nil
+	}
+}
+
+// setList reads a comma-separated list
+func setList(p *[]string) func(string) error {
+	return func(v string) error {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*p = items
+		return nil
+	}
+}
+
+// setRoles reads a comma-separated list of roles, none for no role at all
+// since empty environment variables are ignored
+func setRoles(p *[]Role) func(string) error {
+	return func(v string) error {
+		var names []string
+		if err := setList(&names)(v); err != nil {
+			return err
+		}
+		roles := []Role{}
+		for _, name := range names {
+			if name != "none" {
+				roles = append(roles, Role(name))
+			}
+		}
+		*p = roles
+		return nil
+	}
+}
+
+// LoadConfig reads the configuration from the YAML file at path, if not
+// empty, and the environment. It's validated separately, once the command
+// line has had its say.
+func LoadConfig(path string) (*AppConfig, error) {
+	c := DefaultAppConfig()
+	settings := c.settings()
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		values, err := parseYAML(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, st := range settings {
+			v, ok := values[st.key]
+			if !ok || st.key == "" {
+				continue
+			}
+			if err := st.set(v); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, st.key, err)
+			}
+			delete(values, st.key)
+		}
+		for key := range values {
+			return nil, fmt.Errorf("%s: unknown setting %s", path, key)
+		}
+	}
+
+	for _, st := range settings {
+		v := os.Getenv(st.env)
+		if st.env == "" || v == "" {
+			continue
+		}
+		if err := st.set(v); err != nil {
+			return nil, fmt.Errorf("%s: %w", st.env, err)
+		}
+	}
+	return c, nil
+}
+
+// parseYAML reads the subset of YAML the configuration file needs: nested
+// mappings of scalars and lists of scalars, either inline ([a, b]) or one
+// "- item" per line. It returns the scalars by dotted path, e.g.
+// "server.addr", lists joined with commas.
+func parseYAML(r io.Reader) (map[string]string, error) {
+	type level struct {
+		indent int
+		key    string
+	}
+	values := map[string]string{}
+	var parents []level
+	var list string // key of the block list being read, if any
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := stripYAMLComment(scanner.Text())
+		content := strings.TrimLeft(line, " ")
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", n)
+		}
+		indent := len(line) - len(content)
+		content = strings.TrimSpace(content)
+
+		if item, ok := strings.CutPrefix(content, "-"); ok && list != "" {
+			item = unquoteYAML(strings.TrimSpace(item))
+			if values[list] != "" {
+				item = values[list] + "," + item
+			}
+			values[list] = item
+			continue
+		}
+		list = ""
+
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		key, value, ok := strings.Cut(content, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+		key = strings.TrimSpace(key)
+		if len(parents) > 0 {
+			key = parents[len(parents)-1].key + "." + key
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", n, key)
+		}
+
+		value = strings.TrimSpace(value)
+		switch {
+		case value == "":
+			// a mapping or a block list, depending on the next lines
+			parents = append(parents, level{indent: indent, key: key})
+			list = key
+		case strings.HasPrefix(value, "["):
+			inner, ok := strings.CutSuffix(strings.TrimPrefix(value, "["), "]")
+			if !ok {
+				return nil, fmt.Errorf("line %d: unterminated list", n)
+			}
+			var items []string
+			for _, item := range strings.Split(inner, ",") {
+				if item = unquoteYAML(strings.TrimSpace(item)); item != "" {
+					items = append(items, item)
+				}
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = unquoteYAML(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// stripYAMLComment removes a # comment from line, leaving # inside quotes
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteYAML(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// Validate reports every invalid setting of c at once
+func (c *AppConfig) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch c.Store {
+	case "postgres":
+		if c.Database.DSN == "" {
+			invalid("database.dsn: required by the postgres store, set DATABASE_URL")
+		}
+	case "memory":
+	default:
+		invalid("store: unknown store %q, expected postgres or memory", c.Store)
+	}
+
+	if _, port, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		invalid("server.addr: %q is not host:port", c.Server.Addr)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		invalid("server.addr: invalid port %q", port)
+	}
+	for _, t := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+	} {
+		if t.d <= 0 {
+			invalid("%s: must be positive", t.name)
+		}
+	}
+	if c.Server.ReadHeaderTimeout > c.Server.ReadTimeout {
+		invalid("server.read_header_timeout: can't be longer than server.read_timeout")
+	}
+
+	if c.Database.MaxOpenConns < 0 {
+		invalid("database.max_open_conns: can't be negative, 0 is unlimited")
+	}
+	if c.Database.MaxIdleConns < 0 {
+		invalid("database.max_idle_conns: can't be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		invalid("database.max_idle_conns: can't be more than database.max_open_conns")
+	}
+	if c.Database.ConnMaxLifetime < 0 || c.Database.ConnMaxIdleTime < 0 {
+		invalid("database: connection lifetimes can't be negative, 0 is unlimited")
+	}
+	if c.Database.QueryTimeout <= 0 {
+		invalid("database.query_timeout: must be positive")
+	} else if c.Database.QueryTimeout > c.Server.WriteTimeout {
+		invalid("database.query_timeout: can't be longer than server.write_timeout, the response couldn't be written")
+	}
+
+	if len(c.CORS.AllowedOrigins) == 0 {
+		invalid("cors.allowed_origins: required, * allows any origin")
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			invalid("cors.allowed_origins: %q is not an origin such as https://krixo.dz", origin)
+		}
+	}
+
+	switch c.Mail.Driver {
+	case "log":
+	case "file":
+		if c.Mail.Dir == "" {
+			invalid("mail.dir: required by the file mail driver, set MAIL_DIR")
+		}
+	case "smtp":
+		if c.Mail.SMTP.Host == "" {
+			invalid("mail.smtp.host: required by the smtp mail driver, set SMTP_HOST")
+		}
+		if c.Mail.SMTP.Port <= 0 || c.Mail.SMTP.Port > 65535 {
+			invalid("mail.smtp.port: invalid port %d", c.Mail.SMTP.Port)
+		}
+		if c.Mail.SMTP.Password != "" && c.Mail.SMTP.Username == "" {
+			invalid("mail.smtp.username: required with mail.smtp.password")
+		}
+	default:
+		invalid("mail.driver: unknown driver %q, expected smtp, file or log", c.Mail.Driver)
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		invalid("mail.from: %q is not an email address", c.Mail.From)
+	}
+
+	for _, role := range c.Auth.MFARequiredRoles {
+		if !role.Valid() {
+			invalid("auth.mfa_required_roles: unknown role %q", role)
+		}
+	}
+
+	for _, link := range []struct {
+		name string
+		url  string
+	}{
+		{"links.password_reset", c.Links.PasswordReset},
+		{"links.activation", c.Links.Activation},
+	} {
+		u, err := url.Parse(link.url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			invalid("%s: %q is not a page URL such as https://krixo.dz/activate", link.name, link.url)
+		}
+	}
+
+	return errors.Join(errs...)
+}
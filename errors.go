@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// ErrorKind classifies an error by what went wrong from the client's point
+// of view, which decides the HTTP status it is reported with
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindBadRequest
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindMethodNotAllowed
+	KindTooManyRequests
+	// KindUnavailable is a request the server couldn't serve in time
+	KindUnavailable
+)
+
+// Status is the HTTP status errors of the kind are reported with
+func (k ErrorKind) Status() int {
+	switch k {
+	case KindBadRequest:
+		return http.StatusBadRequest
+	case KindValidation:
+		return http.StatusUnprocessableEntity
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case KindTooManyRequests:
+		return http.StatusTooManyRequests
+	case KindUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error is an error the API can report to clients. Code is a stable
+// identifier clients can switch on; Message is shown as the problem detail.
+// Err, when set, is the underlying cause and is only ever logged.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// withCause returns a copy of e caused by err, for reporting e to the
+// client while logging what actually failed
+func (e *Error) withCause(err error) *Error {
+	c := *e
+	c.Err = err
+	return &c
+}
+
+// KindOf returns the kind of the first *Error in err's chain, KindInternal
+// for errors that aren't typed
+func KindOf(err error) ErrorKind {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	return KindInternal
+}
+
+func newError(kind ErrorKind, code, format string, args ...any) *Error {
+	return &Error{Kind: kind, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func NotFound(code, format string, args ...any) *Error {
+	return newError(KindNotFound, code, format, args...)
+}
+
+func Conflict(code, format string, args ...any) *Error {
+	return newError(KindConflict, code, format, args...)
+}
+
+func BadRequest(code, format string, args ...any) *Error {
+	return newError(KindBadRequest, code, format, args...)
+}
+
+func Invalid(code, format string, args ...any) *Error {
+	return newError(KindValidation, code, format, args...)
+}
+
+func Unauthorized(code, format string, args ...any) *Error {
+	return newError(KindUnauthorized, code, format, args...)
+}
+
+func Forbidden(code, format string, args ...any) *Error {
+	return newError(KindForbidden, code, format, args...)
+}
+
+func TooManyRequests(code, format string, args ...any) *Error {
+	return newError(KindTooManyRequests, code, format, args...)
+}
+
+// Internal wraps an unexpected failure; the client only learns that
+// something went wrong
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Code: "internal", Message: "internal server error", Err: err}
+}
+
+// Postgres error codes translated by dbError
+const (
+	pqUniqueViolation     = "23505"
+	pqForeignKeyViolation = "23503"
+	pqCheckViolation      = "23514"
+	pqInvalidText         = "22P02"
+)
+
+// ErrTimeout is reported for queries cut short by the request's deadline
+var ErrTimeout = &Error{Kind: KindUnavailable, Code: "timeout", Message: "the request took too long, try again later"}
+
+// dbError translates the Postgres errors a client can cause into typed
+// errors, keeping the original as the cause. Others are left as they are
+// and reported as internal errors.
+func dbError(err error) error {
+	var apiErr *Error
+	var pqErr *pq.Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout.withCause(err)
+	}
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch pqErr.Code {
+	case pqUniqueViolation:
+		return &Error{Kind: KindConflict, Code: "already_exists", Message: "resource already exists", Err: err}
+	case pqForeignKeyViolation:
+		return &Error{Kind: KindConflict, Code: "reference_conflict", Message: "resource is referenced by or references another", Err: err}
+	case pqCheckViolation:
+		return &Error{Kind: KindValidation, Code: CodeInvalidValue, Message: "value violates a constraint", Err: err}
+	case pqInvalidText:
+		// Typically a malformed UUID, which can't name any existing row
+		return &Error{Kind: KindNotFound, Code: "not_found", Message: "resource not found", Err: err}
+	}
+	return err
+}
+
+// Problem is an RFC 7807 problem details body
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Fields   []FieldError `json:"fields,omitempty"`
+}
+
+// problemTypeBase prefixes the error code to form the problem type URI
+const problemTypeBase = "urn:krixo:problem:"
+
+// problemFor builds the problem reported for err. Internal errors keep
+// their details out of the body.
+func problemFor(r *http.Request, err error) *Problem {
+	p := &Problem{Instance: r.URL.Path}
+
+	var verr *ValidationError
+	var apiErr *Error
+	switch {
+	case errors.As(err, &verr):
+		p.Status = http.StatusUnprocessableEntity
+		p.Code = "validation_failed"
+		p.Detail = "validation failed"
+		p.Fields = verr.Fields
+
+	case errors.As(dbError(err), &apiErr) && apiErr.Kind != KindInternal:
+		p.Status = apiErr.Kind.Status()
+		p.Code = apiErr.Code
+		// A sentinel wrapped with fmt.Errorf carries its context in the
+		// outer message, but an error with a cause shows only its own
+		p.Detail = apiErr.Message
+		if apiErr.Err == nil {
+			p.Detail = err.Error()
+		}
+
+	default:
+		p.Status = http.StatusInternalServerError
+		p.Code = "internal"
+		p.Detail = "internal server error"
+	}
+
+	p.Type = problemTypeBase + p.Code
+	p.Title = http.StatusText(p.Status)
+	return p
+}
+
+// writeError reports err to the client as problem+json, logging it when
+// it's a server-side failure
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	p := problemFor(r, err)
+	if p.Status >= http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	if encErr := json.NewEncoder(w).Encode(p); encErr != nil {
+		log.Printf("%s %s: writing error response: %v", r.Method, r.URL.Path, encErr)
+	}
+}
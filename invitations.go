@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// invitationTTL is how long a hired worker has to activate their account
+const invitationTTL = 72 * time.Hour
+
+// activationPurpose marks activation tokens, so no other signed token can
+// stand in for one
+const activationPurpose = "activate"
+
+var (
+	ErrAccountInactive    = Forbidden("account_inactive", "account is not active")
+	ErrAlreadyActivated   = Conflict("already_activated", "account has already been activated")
+	ErrInvalidActivation  = BadRequest("invalid_activation_token", "invalid or expired activation link")
+	ErrActivationLinkUsed = BadRequest("activation_token_used", "activation link has already been used")
+	ErrWorkerNotInvitable = Conflict("worker_not_invitable", "only hired, accepted workers can be invited")
+)
+
+// Invitation lets a hired worker set the password of their account, once.
+// The link mailed to the worker carries a signed token naming it.
+type Invitation struct {
+	ID        string
+	WorkerID  string
+	CreatedBy string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type ActivateAccountRequest struct {
+	Token    string `json:"token" validate:"required,max=4096"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+// activationToken signs the token of an invitation's link
+func activationToken(inv *Invitation) (string, error) {
+	return jwtKeys.Sign(jwt.MapClaims{
+		"sub":     inv.WorkerID,
+		"jti":     inv.ID,
+		"purpose": activationPurpose,
+		"iat":     inv.CreatedAt.Unix(),
+		"exp":     inv.ExpiresAt.Unix(),
+	})
+}
+
+// parseActivationToken checks an activation token's signature, expiry and
+// purpose, returning the invitation and worker it names
+func parseActivationToken(token string) (invitationID, workerID string, err error) {
+	parsed, err := validateJWT(token)
+	if err != nil || !parsed.Valid {
+		return "", "", ErrInvalidActivation.withCause(err)
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != activationPurpose {
+		return "", "", ErrInvalidActivation
+	}
+	invitationID, _ = claims["jti"].(string)
+	workerID, _ = claims["sub"].(string)
+	if invitationID == "" || workerID == "" {
+		return "", "", ErrInvalidActivation
+	}
+	return invitationID, workerID, nil
+}
+
+// inviteWorker mails a hired worker the link to activate their account,
+// replacing any earlier invitation
+func (s *APIServer) inviteWorker(ctx context.Context, worker *Worker, invitedBy string) error {
+	if worker.ApplicationStatus != ApplicationHired || !worker.IsAccepted {
+		return ErrWorkerNotInvitable
+	}
+	if worker.Password != "" {
+		return ErrAlreadyActivated
+	}
+
+	inv := &Invitation{
+		WorkerID:  worker.ID,
+		CreatedBy: invitedBy,
+		ExpiresAt: time.Now().Add(invitationTTL).UTC(),
+	}
+	if err := s.store.CreateInvitation(ctx, inv); err != nil {
+		return err
+	}
+	token, err := activationToken(inv)
+	if err != nil {
+		return err
+	}
+
+	link := s.links.Activation + "?token=" + url.QueryEscape(token)
+	return s.mailer.Send(&Mail{
+		To:      worker.Email,
+		Subject: "Activate your Krixo account",
+		Body: fmt.Sprintf("Hello %s,\n\nYour application has been accepted. Set your password to activate your account:\n\n%s\n\nThe link expires on %s.\n",
+			worker.FullName, link, inv.ExpiresAt.In(algiersTime).Format("02/01/2006 15:04")),
+	})
+}
+
+// handleInviteWorker sends a hired worker a new activation link, e.g. when
+// the first one expired
+func (s *APIServer) handleInviteWorker(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	worker, err := s.store.GetAccountByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := s.inviteWorker(r.Context(), worker, currentUserID(r)); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleActivateAccount sets the password of a hired worker from the
+// token of their activation link, which can only be used once
+func (s *APIServer) handleActivateAccount(w http.ResponseWriter, r *http.Request) error {
+	req := new(ActivateAccountRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	invitationID, workerID, err := parseActivationToken(req.Token)
+	if err != nil {
+		return err
+	}
+	if err := s.store.ActivateAccount(r.Context(), invitationID, workerID, req.Password); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// sendInvitation invites a worker just hired. The hiring stands if the
+// mail can't be sent; an admin can send a new link.
+func (s *APIServer) sendInvitation(ctx context.Context, worker *Worker, invitedBy string) {
+	if err := s.inviteWorker(ctx, worker, invitedBy); err != nil {
+		log.Printf("inviting worker %s: %v", worker.ID, err)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// jwtKeys signs and verifies every token issued by the API. main loads it
+// with LoadKeySet before the server starts.
+var jwtKeys *KeySet
+
+var ErrUnknownKey = Unauthorized("invalid_token", "unknown signing key")
+
+// SigningKey is one key of the set. Keys without a private part can only
+// verify tokens, which is how retired keys are kept during a rotation.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private any
+	public  any
+}
+
+// KeySet holds the key new tokens are signed with and every key tokens
+// are still accepted from, indexed by their kid
+type KeySet struct {
+	signing *SigningKey
+	keys    map[string]*SigningKey
+}
+
+// keyConfig is one entry of the JWT_KEYS_FILE json document
+type keyConfig struct {
+	ID             string `json:"kid"`
+	Alg            string `json:"alg"`
+	Secret         string `json:"secret"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
+}
+
+type keySetConfig struct {
+	Signing string      `json:"signing"`
+	Keys    []keyConfig `json:"keys"`
+}
+
+// LoadKeySet reads the signing configuration. JWT_KEYS_FILE points at a
+// json document listing several keys and which one signs, e.g.
+//
+//	{"signing": "2026-10", "keys": [
+//		{"kid": "2026-10", "alg": "EdDSA", "private_key_file": "ed25519.pem"},
+//		{"kid": "2026-04", "alg": "RS256", "public_key_file": "rsa.pub.pem"}]}
+//
+// Otherwise a single key is read from JWT_ALG (HS256, RS256 or EdDSA,
+// default HS256), JWT_KID, and JWT_SECRET or JWT_PRIVATE_KEY_FILE.
+func LoadKeySet() (*KeySet, error) {
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		config := keySetConfig{}
+		if err := json.Unmarshal(b, &config); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", path, err)
+		}
+		return newKeySet(config)
+	}
+
+	key := keyConfig{
+		ID:             os.Getenv("JWT_KID"),
+		Alg:            os.Getenv("JWT_ALG"),
+		Secret:         os.Getenv("JWT_SECRET"),
+		PrivateKeyFile: os.Getenv("JWT_PRIVATE_KEY_FILE"),
+	}
+	if key.ID == "" {
+		key.ID = "default"
+	}
+	if key.Alg == "" {
+		key.Alg = jwt.SigningMethodHS256.Alg()
+	}
+	if key.Alg == jwt.SigningMethodHS256.Alg() && key.Secret == "" {
+		log.Println("JWT_SECRET is not set, using a random secret: tokens won't survive a restart")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		key.Secret = string(secret)
+	}
+
+	return newKeySet(keySetConfig{Signing: key.ID, Keys: []keyConfig{key}})
+}
+
+func newKeySet(config keySetConfig) (*KeySet, error) {
+	ks := &KeySet{keys: map[string]*SigningKey{}}
+	for _, kc := range config.Keys {
+		key, err := loadSigningKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", kc.ID, err)
+		}
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("key %q is listed twice", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	signing, ok := ks.keys[config.Signing]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not in the key set", config.Signing)
+	}
+	if signing.private == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", config.Signing)
+	}
+	ks.signing = signing
+
+	return ks, nil
+}
+
+func loadSigningKey(kc keyConfig) (*SigningKey, error) {
+	if kc.ID == "" {
+		return nil, errors.New("missing kid")
+	}
+	key := &SigningKey{ID: kc.ID}
+
+	switch kc.Alg {
+	case jwt.SigningMethodHS256.Alg():
+		if kc.Secret == "" {
+			return nil, errors.New("HS256 keys need a secret")
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.private = []byte(kc.Secret)
+		key.public = []byte(kc.Secret)
+
+	case jwt.SigningMethodRS256.Alg():
+		key.Method = jwt.SigningMethodRS256
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.private = private
+			key.public = &private.PublicKey
+		} else if kc.PublicKeyFile != "" {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if key.public, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+
+	case jwt.SigningMethodEdDSA.Alg():
+		key.Method = jwt.SigningMethodEdDSA
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			parsed, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			private, ok := parsed.(ed25519.PrivateKey)
+			if !ok {
+				return nil, errors.New("private key is not an Ed25519 key")
+			}
+			key.private = private
+			key.public = private.Public()
+		} else if kc.PublicKeyFile != "" {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if key.public, err = jwt.ParseEdPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", kc.Alg)
+	}
+
+	if key.public == nil {
+		return nil, errors.New("needs a private_key_file or public_key_file")
+	}
+
+	return key, nil
+}
+
+// Sign signs claims with the current signing key, setting the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.private)
+}
+
+// Keyfunc resolves the verification key from the token's kid, making sure
+// the token uses the algorithm that key was configured for. Tokens without
+// a kid are checked against the signing key.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	key := ks.signing
+	if kid, ok := token.Header["kid"]; ok {
+		id, _ := kid.(string)
+		if key, ok = ks.keys[id]; !ok {
+			return nil, fmt.Errorf("%w %v", ErrUnknownKey, kid)
+		}
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.public, nil
+}
+
+// JWK is a public key in RFC 7517 form
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public keys of the set. HMAC secrets are never
+// published, so HS256 keys are left out.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+
+	return jwks
+}
+
+func (s *APIServer) handleJWKS(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	return WriteJSON(w, http.StatusOK, jwtKeys.JWKS())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CommandStatus is where a moving order is in its lifecycle
+type CommandStatus string
+
+const (
+	StatusPending    CommandStatus = "pending"
+	StatusQuoted     CommandStatus = "quoted"
+	StatusAccepted   CommandStatus = "accepted"
+	StatusScheduled  CommandStatus = "scheduled"
+	StatusInProgress CommandStatus = "in_progress"
+	StatusCompleted  CommandStatus = "completed"
+	StatusCancelled  CommandStatus = "cancelled"
+	StatusRejected   CommandStatus = "rejected"
+)
+
+var (
+	ErrInvalidStatus     = Invalid(CodeInvalidValue, "invalid command status")
+	ErrIllegalTransition = Conflict("illegal_transition", "illegal status transition")
+)
+
+// commandTransitions lists, for each status, the statuses it may move to.
+// Completed, cancelled and rejected orders are final.
+var commandTransitions = map[CommandStatus][]CommandStatus{
+	StatusPending:    {StatusQuoted, StatusAccepted, StatusRejected, StatusCancelled},
+	StatusQuoted:     {StatusAccepted, StatusRejected, StatusCancelled},
+	StatusAccepted:   {StatusScheduled, StatusCancelled},
+	StatusScheduled:  {StatusInProgress, StatusCancelled},
+	StatusInProgress: {StatusCompleted},
+	StatusCompleted:  {},
+	StatusCancelled:  {},
+	StatusRejected:   {},
+}
+
+// Valid reports whether s is a known status
+func (s CommandStatus) Valid() bool {
+	_, ok := commandTransitions[s]
+	return ok
+}
+
+// CanTransition reports whether a command may move from s to next
+func (s CommandStatus) CanTransition(next CommandStatus) bool {
+	for _, allowed := range commandTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// finalStatuses are the statuses a command can't leave
+func finalStatuses() []string {
+	var statuses []string
+	for status, next := range commandTransitions {
+		if len(next) == 0 {
+			statuses = append(statuses, string(status))
+		}
+	}
+	sort.Strings(statuses)
+	return statuses
+}
+
+// checkTransition returns ErrInvalidStatus or ErrIllegalTransition when a
+// command can't move from one status to the other
+func checkTransition(from, to CommandStatus) error {
+	if !to.Valid() {
+		return ErrInvalidStatus
+	}
+	if !from.CanTransition(to) {
+		return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, from, to)
+	}
+	return nil
+}
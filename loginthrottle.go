@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ThrottleScope is what failed logins are counted against
+type ThrottleScope string
+
+const (
+	ThrottleAccount ThrottleScope = "account"
+	ThrottleIP      ThrottleScope = "ip"
+)
+
+func (s ThrottleScope) Valid() bool {
+	_, ok := loginPolicies[s]
+	return ok
+}
+
+// loginFailureWindow is how long a failed login is remembered: a scope's
+// count starts over after this long without failures
+const loginFailureWindow = time.Hour
+
+var (
+	ErrLoginThrottled       = TooManyRequests("login_throttled", "too many failed login attempts, try again later")
+	ErrInvalidThrottleScope = Invalid(CodeInvalidValue, "invalid throttle scope")
+	ErrThrottleNotFound     = NotFound("throttle_not_found", "no recent failed logins")
+)
+
+// loginPolicy is how failed logins slow down further attempts: the first
+// freeAttempts failures cost nothing, the next ones double the wait from
+// baseDelay up to maxDelay, and lockoutAfter failures lock the scope out
+// for lockout
+type loginPolicy struct {
+	freeAttempts int
+	lockoutAfter int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	lockout      time.Duration
+}
+
+// loginPolicies are looser for addresses than for accounts, many users
+// possibly sharing an address
+var loginPolicies = map[ThrottleScope]loginPolicy{
+	ThrottleAccount: {freeAttempts: 3, lockoutAfter: 10, baseDelay: time.Second, maxDelay: time.Minute, lockout: 15 * time.Minute},
+	ThrottleIP:      {freeAttempts: 20, lockoutAfter: 100, baseDelay: time.Second, maxDelay: time.Minute, lockout: 30 * time.Minute},
+}
+
+// delay is how long to wait after the last of failures failed logins
+func (p loginPolicy) delay(failures int) time.Duration {
+	switch {
+	case failures >= p.lockoutAfter:
+		return p.lockout
+	case failures <= p.freeAttempts:
+		return 0
+	}
+
+	d := p.baseDelay
+	for i := p.freeAttempts + 1; i < failures && d < p.maxDelay; i++ {
+		d *= 2
+	}
+	if d > p.maxDelay {
+		return p.maxDelay
+	}
+	return d
+}
+
+// LoginThrottle is the failed logins recorded against an account's email
+// or a client address. Attempts are counted as failures until their
+// credentials are found right, so Failures includes attempts in progress.
+// LockedUntil is only set while logins are refused.
+type LoginThrottle struct {
+	Scope         ThrottleScope `json:"scope"`
+	Key           string        `json:"key"`
+	Failures      int           `json:"failures"`
+	LastFailureAt time.Time     `json:"lastfailureat"`
+	LockedUntil   *time.Time    `json:"lockeduntil"`
+}
+
+// setLock sets LockedUntil from the scope's policy
+func (t *LoginThrottle) setLock(now time.Time) {
+	until := t.LastFailureAt.Add(loginPolicies[t.Scope].delay(t.Failures))
+	t.LockedUntil = nil
+	if until.After(now) {
+		t.LockedUntil = &until
+	}
+}
+
+// throttleScopes are the scopes in the order attempts are counted against
+// them, so an address already refused doesn't count against the accounts
+// it tries
+var throttleScopes = []ThrottleScope{ThrottleIP, ThrottleAccount}
+
+// loginKeys are the scopes a login attempt counts against: the account,
+// known or not, and the client's address
+func (s *APIServer) loginKeys(r *http.Request, email string) map[ThrottleScope]string {
+	return map[ThrottleScope]string{
+		ThrottleAccount: normalizeEmail(email),
+		ThrottleIP:      s.clientIP(r),
+	}
+}
+
+// clientIP is the address of the client. X-Forwarded-For is only trusted
+// behind a proxy, its last entry being the one the proxy added.
+func (s *APIServer) clientIP(r *http.Request) string {
+	if s.config.TrustProxy {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			hops := strings.Split(fwd[len(fwd)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginAttempt is a login counted against its scopes, see
+// reserveLoginAttempt
+type loginAttempt struct {
+	keys      map[ThrottleScope]string
+	throttles map[ThrottleScope]*LoginThrottle
+}
+
+// reserveLoginAttempt counts a login attempt as a failure against each of
+// its scopes before its credentials are checked, so that parallel attempts
+// can't all get past the wait the failures before them set. It returns
+// ErrLoginThrottled, setting Retry-After, when a scope is waiting out its
+// failures. The attempt must then be settled by loginFailed,
+// loginSucceeded or, when something else went wrong, releaseLoginAttempt.
+func (s *APIServer) reserveLoginAttempt(ctx context.Context, w http.ResponseWriter, keys map[ThrottleScope]string) (*loginAttempt, error) {
+	attempt := &loginAttempt{keys: keys, throttles: map[ThrottleScope]*LoginThrottle{}}
+	for _, scope := range throttleScopes {
+		t, err := s.store.ReserveLoginAttempt(ctx, scope, keys[scope])
+		if err != nil {
+			s.releaseLoginAttempt(ctx, attempt)
+			return nil, err
+		}
+		if t.LockedUntil != nil {
+			s.releaseLoginAttempt(ctx, attempt)
+			retry := int(math.Ceil(time.Until(*t.LockedUntil).Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			return nil, ErrLoginThrottled
+		}
+		attempt.throttles[scope] = t
+	}
+	return attempt, nil
+}
+
+// loginFailed settles an attempt whose credentials were wrong, which stays
+// counted. Lockouts are logged: the client is told its credentials are
+// wrong either way.
+func (s *APIServer) loginFailed(attempt *loginAttempt) {
+	for scope, t := range attempt.throttles {
+		if t.Failures == loginPolicies[scope].lockoutAfter {
+			log.Printf("login locked out for %s %s after %d failures", scope, t.Key, t.Failures)
+		}
+	}
+}
+
+// loginSucceeded settles an attempt whose credentials were right, forgetting
+// the account's failures. The address only gets the attempt back, so one
+// valid account can't reset its count.
+func (s *APIServer) loginSucceeded(ctx context.Context, attempt *loginAttempt) {
+	key := attempt.keys[ThrottleAccount]
+	err := s.store.ClearLoginThrottle(ctx, ThrottleAccount, key)
+	if err != nil && KindOf(err) != KindNotFound {
+		log.Printf("clearing failed logins of %s: %v", key, err)
+	}
+	delete(attempt.throttles, ThrottleAccount)
+	s.releaseLoginAttempt(ctx, attempt)
+}
+
+// releaseLoginAttempt takes back an attempt whose credentials couldn't be
+// checked
+func (s *APIServer) releaseLoginAttempt(ctx context.Context, attempt *loginAttempt) {
+	for scope := range attempt.throttles {
+		if err := s.store.ReleaseLoginAttempt(ctx, scope, attempt.keys[scope]); err != nil {
+			log.Printf("releasing login attempt for %s %s: %v", scope, attempt.keys[scope], err)
+		}
+	}
+}
+
+// handleGetLoginThrottles lists the accounts and addresses with recent
+// failed logins, locked out or not
+func (s *APIServer) handleGetLoginThrottles(w http.ResponseWriter, r *http.Request) error {
+	throttles, err := s.store.GetLoginThrottles(r.Context())
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, t := range throttles {
+		t.setLock(now)
+	}
+
+	return WriteJSON(w, http.StatusOK, throttles)
+}
+
+// handleClearLoginThrottle forgets the failed logins of an account or
+// address, lifting its lockout
+func (s *APIServer) handleClearLoginThrottle(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	scope := ThrottleScope(vars["scope"])
+	if !scope.Valid() {
+		return ErrInvalidThrottleScope
+	}
+
+	if err := s.store.ClearLoginThrottle(r.Context(), scope, vars["key"]); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
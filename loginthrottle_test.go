@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginPolicyDelay(t *testing.T) {
+	p := loginPolicy{freeAttempts: 3, lockoutAfter: 10, baseDelay: time.Second, maxDelay: 10 * time.Second, lockout: 15 * time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{9, 10 * time.Second},
+		{10, 15 * time.Minute},
+		{50, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+	now := time.Now()
+	policy := loginPolicies[ThrottleAccount]
+
+	locked := &LoginThrottle{Scope: ThrottleAccount, Failures: policy.lockoutAfter, LastFailureAt: now.Add(-5 * time.Minute)}
+	locked.setLock(now)
+	if want := locked.LastFailureAt.Add(policy.lockout); locked.LockedUntil == nil || !locked.LockedUntil.Equal(want) {
+		t.Errorf("locked out until %v, want %v", locked.LockedUntil, want)
+	}
+
+	served := &LoginThrottle{Scope: ThrottleAccount, Failures: policy.lockoutAfter, LastFailureAt: now.Add(-policy.lockout - time.Second)}
+	if served.setLock(now); served.LockedUntil != nil {
+		t.Errorf("locked out until %v after the lockout, want no lock", served.LockedUntil)
+	}
+
+	free := &LoginThrottle{Scope: ThrottleAccount, Failures: policy.freeAttempts, LastFailureAt: now}
+	if free.setLock(now); free.LockedUntil != nil {
+		t.Errorf("locked out until %v after the free attempts, want no lock", free.LockedUntil)
+	}
+}
+
+func TestParallelLoginsAreThrottled(t *testing.T) {
+	a := newWorkerAPI(t)
+	w := a.createAccount("target@krixo.test", RoleWorker)
+	policy := loginPolicies[ThrottleAccount]
+
+	const attempts = 20
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := `{"email":"` + w.Email + `","password":"Wr0ng!Passw0rd"}`
+			resp, err := a.server.Client().Post(a.server.URL+"/Regestration", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	// The free attempts, then one that sets the first wait
+	checked := policy.freeAttempts + 1
+	if counts[http.StatusUnauthorized] != checked || counts[http.StatusTooManyRequests] != attempts-checked {
+		t.Errorf("parallel wrong passwords got %v, want %d checked and the rest throttled", counts, checked)
+	}
+
+	throttle, err := a.store.GetLoginThrottle(context.Background(), ThrottleAccount, w.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if throttle.Failures != checked {
+		t.Errorf("account failures = %d, want %d", throttle.Failures, checked)
+	}
+}
+
+func TestSuccessfulLoginsDontCountAgainstTheAddress(t *testing.T) {
+	a := newWorkerAPI(t)
+	w := a.createAccount("regular@krixo.test", RoleWorker)
+
+	for i := 0; i <= loginPolicies[ThrottleIP].freeAttempts+1; i++ {
+		if resp, body := a.do(http.MethodPost, "/Regestration", nil, &LoginRequest{Email: w.Email, Password: testPassword}); resp.StatusCode != http.StatusOK {
+			t.Fatalf("login %d = %d %s, want 200", i+1, resp.StatusCode, body)
+		}
+	}
+	if _, err := a.store.GetLoginThrottle(context.Background(), ThrottleIP, "127.0.0.1"); KindOf(err) != KindNotFound {
+		t.Errorf("address throttle error = %v, want none recorded", err)
+	}
+}
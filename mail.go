@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mail is an email sent by the API
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers the emails of account flows
+type Mailer interface {
+	Send(*Mail) error
+}
+
+// LoadMailer builds the mailer of the validated config's driver
+func LoadMailer(config MailConfig) (Mailer, error) {
+	switch config.Driver {
+	case "log":
+		return logMailer{}, nil
+	case "file":
+		if err := os.MkdirAll(config.Dir, 0o700); err != nil {
+			return nil, fmt.Errorf("creating mail directory: %w", err)
+		}
+		return &fileMailer{dir: config.Dir, from: config.From}, nil
+	case "smtp":
+		host := config.SMTP.Host
+		m := &smtpMailer{addr: net.JoinHostPort(host, strconv.Itoa(config.SMTP.Port)), from: config.From}
+		if config.SMTP.Username != "" {
+			m.auth = smtp.PlainAuth("", config.SMTP.Username, config.SMTP.Password, host)
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", config.Driver)
+	}
+}
+
+// headerValue strips line breaks, so user input can't add headers
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// message formats m as an RFC 5322 plain text message
+func (m *Mail) message(from string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(m.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// smtpMailer sends mail through an SMTP relay, with STARTTLS when the
+// server offers it
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (s *smtpMailer) Send(m *Mail) error {
+	if err := smtp.SendMail(s.addr, s.auth, s.from, []string{headerValue(m.To)}, m.message(s.from)); err != nil {
+		return fmt.Errorf("sending mail: %w", err)
+	}
+	return nil
+}
+
+// fileMailer writes each mail to its own .eml file in dir instead of
+// sending it, for local testing
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+func (f *fileMailer) Send(m *Mail) error {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(b) + ".eml"
+
+	if err := os.WriteFile(filepath.Join(f.dir, name), m.message(f.from), 0o600); err != nil {
+		return fmt.Errorf("writing mail: %w", err)
+	}
+	return nil
+}
+
+// logMailer writes mail to the log instead of sending it, for development
+type logMailer struct{}
+
+func (logMailer) Send(m *Mail) error {
+	log.Printf("mail to %s: %s\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
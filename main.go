@@ -1,22 +1,127 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "maintenance" {
+		if err := runMaintenance(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file, overridden by the environment")
+	storeKind := flag.String("store", "", "storage backend: postgres (default) or memory")
+	rollback := flag.Int("rollback", 0, "roll back the last N schema migrations and exit")
+	bootstrapEmail := flag.String("bootstrap-admin", "", "create the first admin account with this email (password from ADMIN_PASSWORD) and exit")
+	flag.Parse()
+
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *storeKind != "" {
+		config.Store = *storeKind
+	}
+	if *rollback > 0 {
+		config.Store = "postgres"
+	}
+	if err := config.Validate(); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	allowedOrigins = config.CORS.AllowedOrigins
+
+	if *rollback > 0 {
+		store, err := NewPostgresStore(config.Database)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+		if err := store.MigrateDown(*rollback); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	keys, err := LoadKeySet()
+	if err != nil {
+		log.Fatal(err)
+	}
+	jwtKeys = keys
 
-	store, err := NewPostgresStore()
+	mfaRequiredRoles = mfaPolicy(config.Auth)
+
+	mailer, err := LoadMailer(config.Mail)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := store.Init(); err != nil {
+	store, err := newStore(config)
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	server := NewAPIServer("0.0.0.0:3000", store)
-	fmt.Println("Coonectect to server ")
-	server.Run()
+	if *bootstrapEmail != "" {
+		err := bootstrapAdmin(context.Background(), store, *bootstrapEmail, os.Getenv("ADMIN_PASSWORD"))
+		store.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Admin account created for", *bootstrapEmail)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := NewAPIServer(config.Server, store)
+	server.mailer = mailer
+	server.links = config.Links
+	server.queryTimeout = config.Database.QueryTimeout
+	err = server.Run(ctx)
+	if cerr := store.Close(); cerr != nil {
+		log.Println("closing the store:", cerr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped")
+}
+
+// getenv returns the environment variable key, or fallback when it's unset
+// or empty
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// newStore opens and initialises the storage backend of config
+func newStore(config *AppConfig) (Storage, error) {
+	switch config.Store {
+	case "postgres":
+		store, err := NewPostgresStore(config.Database)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Init(); err != nil {
+			store.Close()
+			return nil, err
+		}
+		return store, nil
+	case "memory":
+		log.Println("Using in-memory storage, data will not be persisted")
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", config.Store)
+	}
 }
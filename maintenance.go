@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maintenanceTables are the tables maintenance may touch, parents before
+// the tables referencing them. Table names given on the command line must
+// be one of these, and are quoted all the same.
+var maintenanceTables = []string{
+	"worker",
+	"commands",
+	"command_status_history",
+	"command_assignments",
+	"rate_cards",
+	"refresh_tokens",
+	"api_keys",
+	"worker_availability",
+	"time_off",
+	"application_status_history",
+	"application_notes",
+	"worker_invitations",
+	"password_resets",
+	"login_throttles",
+	"worker_totp",
+	"totp_recovery_codes",
+}
+
+// destructiveEnvs are the values of APP_ENV in which maintenance may
+// delete data. Anything else, including no APP_ENV, counts as production.
+var destructiveEnvs = []string{"development", "test"}
+
+var ErrProductionEnv = errors.New("refusing to delete data: APP_ENV must be development or test")
+
+const maintenanceUsage = `usage: krixo maintenance <command> [flags]
+
+commands:
+  backup   snapshot every table to a directory
+  reset    empty tables, after a backup (development and test only)
+  seed     create the default rate card and demo accounts (development and test only)
+
+Run "krixo maintenance <command> -h" for the flags of a command.`
+
+// runMaintenance runs the maintenance subcommand with its arguments. It
+// works on Postgres, the only store with data to keep.
+func runMaintenance(args []string) error {
+	if len(args) == 0 {
+		return errors.New(maintenanceUsage)
+	}
+	command, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("maintenance "+command, flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file, overridden by the environment")
+	backupDir := fs.String("backup-dir", getenv("BACKUP_DIR", "backups"), "directory backups are written to")
+	var tables *string
+	var confirm *bool
+	switch command {
+	case "backup", "seed":
+	case "reset":
+		tables = fs.String("tables", "", "comma-separated tables to empty, all of them if unset")
+		confirm = fs.Bool("yes", false, "confirm deleting the data")
+	default:
+		return errors.New(maintenanceUsage)
+	}
+	fs.Parse(args)
+
+	if command != "backup" {
+		if err := checkDestructiveEnv(); err != nil {
+			return err
+		}
+	}
+	password := os.Getenv("SEED_PASSWORD")
+	if command == "seed" && password == "" {
+		return errors.New("SEED_PASSWORD is required to seed accounts")
+	}
+	names := maintenanceTables
+	if command == "reset" {
+		if *tables != "" {
+			names = strings.Split(*tables, ",")
+		}
+		if err := checkMaintenanceTables(names); err != nil {
+			return err
+		}
+		if !*confirm {
+			return fmt.Errorf("reset deletes every row of %s: run again with -yes to confirm", strings.Join(names, ", "))
+		}
+	}
+
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	config.Store = "postgres"
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	store, err := NewPostgresStore(config.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+	if err := store.Init(); err != nil {
+		return err
+	}
+
+	switch command {
+	case "backup":
+		dir, err := store.Backup(ctx, *backupDir, "backup")
+		if err != nil {
+			return err
+		}
+		log.Println("Backup written to", dir)
+		return nil
+
+	case "reset":
+		dir, err := store.Backup(ctx, *backupDir, "reset")
+		if err != nil {
+			return fmt.Errorf("backup failed, nothing was deleted: %w", err)
+		}
+		log.Println("Backup written to", dir)
+		if err := store.Truncate(ctx, names); err != nil {
+			return err
+		}
+		log.Println("Emptied", strings.Join(names, ", "))
+		return nil
+
+	default:
+		dir, err := store.Backup(ctx, *backupDir, "seed")
+		if err != nil {
+			return fmt.Errorf("backup failed, nothing was seeded: %w", err)
+		}
+		log.Println("Backup written to", dir)
+		return seed(ctx, store, password)
+	}
+}
+
+// checkDestructiveEnv refuses destructive operations outside of the
+// environments listed in destructiveEnvs
+func checkDestructiveEnv() error {
+	if !contains(destructiveEnvs, os.Getenv("APP_ENV")) {
+		return ErrProductionEnv
+	}
+	return nil
+}
+
+// checkMaintenanceTables refuses table names that aren't maintenanceTables
+func checkMaintenanceTables(names []string) error {
+	for _, name := range names {
+		if !contains(maintenanceTables, name) {
+			return fmt.Errorf("unknown table %q, expected one of %s", name, strings.Join(maintenanceTables, ", "))
+		}
+	}
+	return nil
+}
+
+// BackupManifest describes a backup directory, next to one <table>.json
+// file per table holding its rows as a JSON array. A table can be restored
+// with INSERT INTO t SELECT * FROM json_populate_recordset(null::t, $1).
+type BackupManifest struct {
+	Operation string         `json:"operation"`
+	CreatedAt time.Time      `json:"createdat"`
+	Rows      map[string]int `json:"rows"`
+}
+
+// Backup snapshots every maintenance table into a new directory under dir,
+// from a single consistent view of the database, and returns its path.
+// Operation is the maintenance it's taken before.
+func (s *PostgresStore) Backup(ctx context.Context, dir, operation string) (string, error) {
+	now := time.Now().UTC()
+	path := filepath.Join(dir, now.Format("20060102T150405Z")+"-"+operation)
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return "", fmt.Errorf("creating backup directory: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	manifest := &BackupManifest{Operation: operation, CreatedAt: now, Rows: map[string]int{}}
+	for _, table := range maintenanceTables {
+		var rows []byte
+		var count int
+		query := fmt.Sprintf(`SELECT coalesce(json_agg(t), '[]'), count(*) FROM %s t`, pq.QuoteIdentifier(table))
+		if err := tx.QueryRowContext(ctx, query).Scan(&rows, &count); err != nil {
+			return "", fmt.Errorf("backing up %s: %w", table, err)
+		}
+		if err := os.WriteFile(filepath.Join(path, table+".json"), rows, 0o600); err != nil {
+			return "", fmt.Errorf("backing up %s: %w", table, err)
+		}
+		manifest.Rows[table] = count
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(path, "manifest.json"), b, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Truncate empties tables, which must be maintenanceTables, along with the
+// rows referencing them. An emptied rate_cards gets the default rate card
+// back, like a freshly migrated database.
+func (s *PostgresStore) Truncate(ctx context.Context, tables []string) error {
+	if err := checkMaintenanceTables(tables); err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(tables))
+	for i, table := range tables {
+		quoted[i] = pq.QuoteIdentifier(table)
+	}
+	if _, err := s.db.ExecContext(ctx, "TRUNCATE "+strings.Join(quoted, ", ")+" CASCADE"); err != nil {
+		return fmt.Errorf("emptying tables: %w", err)
+	}
+
+	if _, err := s.GetRateCard(ctx); KindOf(err) == KindNotFound {
+		return s.SaveRateCard(ctx, DefaultRateCard.clone())
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// seed creates what a development database needs to be used: the default
+// rate card and one account of each role, all with password
+func seed(ctx context.Context, store Storage, password string) error {
+	if _, err := store.GetRateCard(ctx); KindOf(err) == KindNotFound {
+		if err := store.SaveRateCard(ctx, DefaultRateCard.clone()); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	for _, role := range []Role{RoleAdmin, RoleDispatcher, RoleWorker} {
+		email := string(role) + "@krixo.test"
+		err := store.CreateWorker(ctx, &Worker{
+			FullName:          "Demo " + string(role),
+			Number:            "0550000000",
+			Email:             email,
+			Password:          password,
+			Position:          string(role),
+			IsAccepted:        true,
+			Role:              role,
+			ApplicationStatus: ApplicationHired,
+		})
+		if errors.Is(err, ErrEmailExists) {
+			log.Println("Skipped existing account", email)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("seeding %s: %w", email, err)
+		}
+		log.Println("Created account", email)
+	}
+	return nil
+}
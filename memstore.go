@@ -0,0 +1,1424 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process Storage used for tests and local runs
+// without Postgres. It mirrors the semantics and errors of PostgresStore.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	commands []*Command
+	history  []*CommandStatusChange
+	workers  []*Worker
+	sessions []*RefreshToken
+	apiKeys  []*APIKey
+	// assignments are the crews of commands, in the order workers joined
+	assignments []*Assignment
+	// availability are the weekly slots of workers, by worker id
+	availability map[string][]AvailabilitySlot
+	timeOff      []*TimeOff
+	// applicationHistory and applicationNotes are the review trail of
+	// workers' applications
+	applicationHistory []*ApplicationStatusChange
+	applicationNotes   []*ApplicationNote
+	invitations        []*Invitation
+	passwordResets     []*PasswordReset
+	loginThrottles     []*LoginThrottle
+	// totp are the authenticator secrets of workers, by worker id
+	totp          map[string]*TOTP
+	recoveryCodes []*recoveryCode
+	// rateCards are the versions of the rate card, latest last
+	rateCards []*RateCard
+}
+
+// NewMemoryStore returns an empty store priced with DefaultRateCard, like
+// a freshly migrated database
+func NewMemoryStore() *MemoryStore {
+	card := DefaultRateCard.clone()
+	card.ID = 1
+	card.CreatedAt = time.Now().UTC()
+	return &MemoryStore{
+		rateCards:    []*RateCard{card},
+		availability: make(map[string][]AvailabilitySlot),
+		totp:         make(map[string]*TOTP),
+	}
+}
+
+// recoveryCode is a stored two-factor recovery code
+type recoveryCode struct {
+	workerID string
+	hash     string
+	used     bool
+}
+
+func (s *MemoryStore) Init() error {
+	return nil
+}
+
+func (s *MemoryStore) CreateCommand(ctx context.Context, command *Command) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	command.ID = id
+	command.CreatedAt = time.Now().UTC()
+	c := *command
+	s.commands = append(s.commands, &c)
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteCommand(ctx context.Context, command *Command) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, c := range s.commands {
+		if c.ID == command.ID {
+			s.commands = append(s.commands[:i], s.commands[i+1:]...)
+			s.deleteHistory(command.ID)
+			s.deleteAssignments(func(a *Assignment) bool { return a.CommandID == command.ID })
+			return nil
+		}
+	}
+
+	return NotFound("command_not_found", "no command found with ID %v", command.ID)
+}
+
+func (s *MemoryStore) GetCommands(ctx context.Context, q *CommandQuery) (*Page[*Command], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	commands := []*Command{}
+	for _, c := range s.commands {
+		if q.matches(c) {
+			command := *c
+			commands = append(commands, &command)
+		}
+	}
+
+	return paginate(commands, commandID, q.Sort, commandSortFields, q.Cursor, q.Limit)
+}
+
+func (s *MemoryStore) GetCommandByID(ctx context.Context, id string) (*Command, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range s.commands {
+		if c.ID == id {
+			command := *c
+			return &command, nil
+		}
+	}
+
+	return nil, NotFound("command_not_found", "command %s not found", id)
+}
+
+// CreateUser validates the credentials and returns the password hash
+func (s *MemoryStore) CreateUser(ctx context.Context, email, password string) (string, error) {
+	return createUser(email, password, func(email string) error {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		if s.findWorker(func(w *Worker) bool { return w.Email == email }) != nil {
+			return ErrEmailExists
+		}
+		return nil
+	})
+}
+
+func (s *MemoryStore) CreateWorker(ctx context.Context, worker *Worker) error {
+	worker.Email = normalizeEmail(worker.Email)
+	hashedpassword, err := s.CreateUser(ctx, worker.Email, worker.Password)
+	if err != nil {
+		return err
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Re-check under the write lock, like the UNIQUE constraint on worker.email
+	if s.findWorker(func(w *Worker) bool { return w.Email == worker.Email }) != nil {
+		return ErrEmailExists
+	}
+
+	w := *worker
+	w.ID = id
+	w.CreatedAt = time.Now().UTC()
+	if w.Role == "" {
+		w.Role = RoleWorker
+	}
+	w.ApplicationStatus = initialApplicationStatus(worker)
+	w.Password = hashedpassword
+	s.workers = append(s.workers, &w)
+
+	worker.ID, worker.CreatedAt, worker.Role, worker.ApplicationStatus = w.ID, w.CreatedAt, w.Role, w.ApplicationStatus
+
+	return nil
+}
+
+func (s *MemoryStore) GetWorkers(ctx context.Context, q *WorkerQuery) (*Page[*Worker], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	workers := []*Worker{}
+	for _, w := range s.workers {
+		if q.matches(w) {
+			worker := *w
+			workers = append(workers, &worker)
+		}
+	}
+
+	return paginate(workers, workerID, q.Sort, workerSortFields, q.Cursor, q.Limit)
+}
+
+// Register returns the worker with email if password is theirs, and
+// ErrInvalidCredentials otherwise
+func (s *MemoryStore) Register(ctx context.Context, password string, email string) (*Worker, error) {
+	worker, err := s.GetWorkerByEmail(ctx, email)
+	return authenticate(worker, err, password)
+}
+
+func (s *MemoryStore) GetWorkerByEmail(ctx context.Context, email string) (*Worker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	email = normalizeEmail(email)
+	if w := s.findWorker(func(w *Worker) bool { return w.Email == email }); w != nil {
+		worker := *w
+		return &worker, nil
+	}
+
+	return nil, NotFound("worker_not_found", "Worker %s not found", email)
+}
+
+func (s *MemoryStore) GetAccountByID(ctx context.Context, id string) (*Worker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if w := s.findWorker(func(w *Worker) bool { return w.ID == id }); w != nil {
+		worker := *w
+		return &worker, nil
+	}
+
+	return nil, NotFound("worker_not_found", "account %s not found", id)
+}
+
+func (s *MemoryStore) UpdateCommand(ctx context.Context, command *Command) error {
+	_, err := s.TransitionCommand(ctx, command.ID, command.Status, "", "")
+	return err
+}
+
+func (s *MemoryStore) TransitionCommand(ctx context.Context, id string, to CommandStatus, changedBy, note string) (*Command, error) {
+	changeID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.commands {
+		if c.ID != id {
+			continue
+		}
+		if err := checkTransition(c.Status, to); err != nil {
+			return nil, err
+		}
+
+		s.history = append(s.history, &CommandStatusChange{
+			ID:         changeID,
+			CommandID:  id,
+			FromStatus: c.Status,
+			ToStatus:   to,
+			ChangedBy:  changedBy,
+			Note:       note,
+			ChangedAt:  time.Now().UTC(),
+		})
+		c.Status = to
+
+		command := *c
+		return &command, nil
+	}
+
+	return nil, NotFound("command_not_found", "no command found with ID %s", id)
+}
+
+func (s *MemoryStore) PatchCommand(ctx context.Context, id string, patch *CommandPatch, changedBy string) (*Command, error) {
+	changeID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.commands {
+		if c.ID != id {
+			continue
+		}
+
+		// Check the transition first so a refused patch changes nothing
+		transition := patch.Status != nil && *patch.Status != c.Status
+		if transition {
+			if err := checkTransition(c.Status, *patch.Status); err != nil {
+				return nil, err
+			}
+		}
+
+		// Patch a copy, so a rescheduling that double-books the crew
+		// changes nothing either
+		next := *c
+		patchField(&next.FullName, patch.FullName)
+		patchField(&next.Number, patch.Number)
+		patchField(&next.Flor, patch.Flor)
+		patchField(&next.Elevator, patch.Elevator)
+		patchField(&next.Itemtype, patch.Itemtype)
+		patchField(&next.Service, patch.Service)
+		patchField(&next.Workers, patch.Workers)
+		patchField(&next.Start, patch.Start)
+		patchField(&next.Distination, patch.Distination)
+		if patch.MoveDate != nil {
+			moveDate := *patch.MoveDate
+			next.MoveDate = &moveDate
+		}
+		patchField(&next.DurationMinutes, patch.DurationMinutes)
+		patchField(&next.Prix, patch.Prix)
+		if transition {
+			next.Status = *patch.Status
+		}
+
+		if patch.MoveDate != nil || patch.DurationMinutes != nil {
+			for _, a := range s.assignments {
+				if a.CommandID != id {
+					continue
+				}
+				if other := s.overlappingJob(a.WorkerID, &next); other != "" {
+					return nil, doubleBooked(a.WorkerID, other)
+				}
+			}
+		}
+
+		if transition {
+			s.history = append(s.history, &CommandStatusChange{
+				ID:         changeID,
+				CommandID:  id,
+				FromStatus: c.Status,
+				ToStatus:   *patch.Status,
+				ChangedBy:  changedBy,
+				Note:       patch.Note,
+				ChangedAt:  time.Now().UTC(),
+			})
+		}
+		*c = next
+
+		command := *c
+		return &command, nil
+	}
+
+	return nil, NotFound("command_not_found", "no command found with ID %s", id)
+}
+
+// patchField sets *dst to *v, unless v is nil
+func patchField[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func (s *MemoryStore) GetCommandHistory(ctx context.Context, id string) ([]*CommandStatusChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	changes := []*CommandStatusChange{}
+	for _, h := range s.history {
+		if h.CommandID == id {
+			change := *h
+			changes = append(changes, &change)
+		}
+	}
+
+	return changes, nil
+}
+
+func (s *MemoryStore) TransitionApplication(ctx context.Context, id string, to ApplicationStatus, changedBy, note string) (*Worker, error) {
+	changeID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.findWorker(func(w *Worker) bool { return w.ID == id })
+	if w == nil {
+		return nil, NotFound("worker_not_found", "no worker found with ID %s", id)
+	}
+	if err := checkApplicationTransition(w.ApplicationStatus, to); err != nil {
+		return nil, err
+	}
+
+	s.applicationHistory = append(s.applicationHistory, &ApplicationStatusChange{
+		ID:         changeID,
+		WorkerID:   id,
+		FromStatus: w.ApplicationStatus,
+		ToStatus:   to,
+		ChangedBy:  changedBy,
+		Note:       note,
+		ChangedAt:  time.Now().UTC(),
+	})
+	w.ApplicationStatus = to
+	if to == ApplicationHired {
+		w.IsAccepted = true
+	}
+
+	worker := *w
+	return &worker, nil
+}
+
+func (s *MemoryStore) GetApplicationHistory(ctx context.Context, workerID string) ([]*ApplicationStatusChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	changes := []*ApplicationStatusChange{}
+	for _, h := range s.applicationHistory {
+		if h.WorkerID == workerID {
+			change := *h
+			changes = append(changes, &change)
+		}
+	}
+
+	return changes, nil
+}
+
+func (s *MemoryStore) AddApplicationNote(ctx context.Context, note *ApplicationNote) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findWorker(func(w *Worker) bool { return w.ID == note.WorkerID }) == nil {
+		return NotFound("worker_not_found", "no worker found with ID %s", note.WorkerID)
+	}
+	note.ID = id
+	note.CreatedAt = time.Now().UTC()
+	n := *note
+	s.applicationNotes = append(s.applicationNotes, &n)
+
+	return nil
+}
+
+func (s *MemoryStore) GetApplicationNotes(ctx context.Context, workerID string) ([]*ApplicationNote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	notes := []*ApplicationNote{}
+	for _, n := range s.applicationNotes {
+		if n.WorkerID == workerID {
+			note := *n
+			notes = append(notes, &note)
+		}
+	}
+
+	return notes, nil
+}
+
+func (s *MemoryStore) CreateInvitation(ctx context.Context, inv *Invitation) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findWorker(func(w *Worker) bool { return w.ID == inv.WorkerID }) == nil {
+		return NotFound("worker_not_found", "no worker found with ID %s", inv.WorkerID)
+	}
+	s.deleteInvitations(func(old *Invitation) bool { return old.WorkerID == inv.WorkerID && old.UsedAt == nil })
+
+	inv.ID = id
+	inv.CreatedAt = time.Now().UTC()
+	invitation := *inv
+	s.invitations = append(s.invitations, &invitation)
+
+	return nil
+}
+
+func (s *MemoryStore) ActivateAccount(ctx context.Context, invitationID, workerID, password string) error {
+	hash, err := newPasswordHash(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, inv := range s.invitations {
+		if inv.ID != invitationID || inv.WorkerID != workerID {
+			continue
+		}
+		if inv.UsedAt != nil {
+			return ErrActivationLinkUsed
+		}
+		if !time.Now().Before(inv.ExpiresAt) {
+			return ErrInvalidActivation
+		}
+
+		w := s.findWorker(func(w *Worker) bool { return w.ID == workerID })
+		if w == nil {
+			return NotFound("worker_not_found", "no worker found with ID %s", workerID)
+		}
+		if !w.IsAccepted {
+			return ErrAccountInactive
+		}
+
+		now := time.Now().UTC()
+		w.Password = hash
+		inv.UsedAt = &now
+		return nil
+	}
+
+	// Replaced by a newer invitation, or never issued
+	return ErrInvalidActivation
+}
+
+func (s *MemoryStore) CreatePasswordReset(ctx context.Context, reset *PasswordReset) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findWorker(func(w *Worker) bool { return w.ID == reset.WorkerID }) == nil {
+		return NotFound("worker_not_found", "no worker found with ID %s", reset.WorkerID)
+	}
+	s.deletePasswordResets(reset.WorkerID, true)
+
+	reset.ID = id
+	reset.CreatedAt = time.Now().UTC()
+	r := *reset
+	s.passwordResets = append(s.passwordResets, &r)
+
+	return nil
+}
+
+func (s *MemoryStore) ResetPassword(ctx context.Context, tokenHash, password string) (string, error) {
+	hash, err := newPasswordHash(password)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, reset := range s.passwordResets {
+		if reset.TokenHash != tokenHash || reset.UsedAt != nil || !time.Now().Before(reset.ExpiresAt) {
+			continue
+		}
+		w := s.findWorker(func(w *Worker) bool { return w.ID == reset.WorkerID })
+		if w == nil {
+			break
+		}
+
+		now := time.Now().UTC()
+		reset.UsedAt = &now
+		w.Password = hash
+		return w.ID, nil
+	}
+
+	return "", ErrInvalidResetToken
+}
+
+func (s *MemoryStore) UpdateWorker(ctx context.Context, worker *Worker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w := s.findWorker(func(w *Worker) bool { return w.ID == worker.ID }); w != nil {
+		w.IsAccepted = worker.IsAccepted
+		return nil
+	}
+
+	return NotFound("worker_not_found", "no worker found with ID %s", worker.ID)
+}
+
+func (s *MemoryStore) PatchWorker(ctx context.Context, id string, patch *WorkerPatch) (*Worker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w := s.findWorker(func(w *Worker) bool { return w.ID == id }); w != nil {
+		patchField(&w.FullName, patch.FullName)
+		patchField(&w.Number, patch.Number)
+		patchField(&w.Position, patch.Position)
+		patchField(&w.Experience, patch.Experience)
+		patchField(&w.Message, patch.Message)
+		patchField(&w.IsAccepted, patch.IsAccepted)
+
+		worker := *w
+		return &worker, nil
+	}
+
+	return nil, NotFound("worker_not_found", "no worker found with ID %s", id)
+}
+
+// DeleteWorker deletes a worker along with their sessions, like the
+// foreign keys of PostgresStore
+func (s *MemoryStore) DeleteWorker(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, w := range s.workers {
+		if w.ID != id {
+			continue
+		}
+		s.workers = append(s.workers[:i], s.workers[i+1:]...)
+
+		sessions := s.sessions[:0]
+		for _, t := range s.sessions {
+			if t.WorkerID != id {
+				sessions = append(sessions, t)
+			}
+		}
+		s.sessions = sessions
+		s.deleteAssignments(func(a *Assignment) bool { return a.WorkerID == id })
+		delete(s.availability, id)
+		s.deleteTimeOff(id)
+		s.deleteApplication(id)
+		s.deleteInvitations(func(inv *Invitation) bool { return inv.WorkerID == id })
+		s.deletePasswordResets(id, false)
+		delete(s.totp, id)
+		s.deleteRecoveryCodes(id)
+
+		for _, k := range s.apiKeys {
+			if k.CreatedBy == id {
+				k.CreatedBy = ""
+			}
+		}
+		return nil
+	}
+
+	return NotFound("worker_not_found", "no worker found with ID %s", id)
+}
+
+func (s *MemoryStore) AssignWorker(ctx context.Context, a *Assignment) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	command := s.findCommand(a.CommandID)
+	if command == nil {
+		return NotFound("command_not_found", "command %s not found", a.CommandID)
+	}
+	worker := s.findWorker(func(w *Worker) bool { return w.ID == a.WorkerID })
+	if worker == nil {
+		return NotFound("worker_not_found", "account %s not found", a.WorkerID)
+	}
+
+	crew := []*Assignment{}
+	for _, existing := range s.assignments {
+		if existing.CommandID == a.CommandID {
+			crew = append(crew, existing)
+		}
+	}
+	if err := checkAssignment(command, worker, crew, a); err != nil {
+		return err
+	}
+	if other := s.overlappingJob(a.WorkerID, command); other != "" {
+		return doubleBooked(a.WorkerID, other)
+	}
+	if start, end := command.window(); s.hasTimeOff(a.WorkerID, start, end) {
+		return ErrWorkerOnTimeOff
+	}
+
+	a.ID = id
+	a.AssignedAt = time.Now().UTC()
+	assignment := *a
+	s.assignments = append(s.assignments, &assignment)
+
+	return nil
+}
+
+func (s *MemoryStore) UnassignWorker(ctx context.Context, commandID, workerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, a := range s.assignments {
+		if a.CommandID == commandID && a.WorkerID == workerID {
+			s.assignments = append(s.assignments[:i], s.assignments[i+1:]...)
+			return nil
+		}
+	}
+
+	return NotFound("assignment_not_found", "worker %s is not assigned to command %s", workerID, commandID)
+}
+
+func (s *MemoryStore) GetAssignments(ctx context.Context, commandID string) ([]*Assignment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	assignments := []*Assignment{}
+	for _, a := range s.assignments {
+		if a.CommandID == commandID {
+			assignment := *a
+			assignments = append(assignments, &assignment)
+		}
+	}
+
+	return assignments, nil
+}
+
+func (s *MemoryStore) GetWorkerJobs(ctx context.Context, workerID string) ([]*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := []*Job{}
+	for _, a := range s.assignments {
+		if a.WorkerID != workerID {
+			continue
+		}
+		assignment, command := *a, *s.findCommand(a.CommandID)
+		jobs = append(jobs, &Job{Assignment: &assignment, Command: &command})
+	}
+
+	// By move date like PostgresStore, commands without one last
+	sort.SliceStable(jobs, func(i, j int) bool {
+		a, b := jobs[i].Command.MoveDate, jobs[j].Command.MoveDate
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.Before(*b)
+	})
+	return jobs, nil
+}
+
+// overlappingJob returns the id of a busy command other than command the
+// worker is assigned to during it, or ""; callers must hold s.mu
+func (s *MemoryStore) overlappingJob(workerID string, command *Command) string {
+	if command.MoveDate == nil || !command.busy() {
+		return ""
+	}
+	start, end := command.window()
+
+	for _, a := range s.assignments {
+		if a.WorkerID != workerID || a.CommandID == command.ID {
+			continue
+		}
+		other := s.findCommand(a.CommandID)
+		if other == nil || other.MoveDate == nil || !other.busy() {
+			continue
+		}
+		if otherStart, otherEnd := other.window(); overlaps(start, end, otherStart, otherEnd) {
+			return other.ID
+		}
+	}
+	return ""
+}
+
+// hasTimeOff reports whether the worker has approved time off overlapping
+// start to end; callers must hold s.mu
+func (s *MemoryStore) hasTimeOff(workerID string, start, end time.Time) bool {
+	for _, t := range s.timeOff {
+		if t.WorkerID == workerID && t.Status == TimeOffApproved && overlaps(start, end, t.Start, t.End) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) GetAvailability(ctx context.Context, workerID string) ([]AvailabilitySlot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]AvailabilitySlot{}, s.availability[workerID]...), nil
+}
+
+func (s *MemoryStore) SetAvailability(ctx context.Context, workerID string, slots []AvailabilitySlot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findWorker(func(w *Worker) bool { return w.ID == workerID }) == nil {
+		return NotFound("worker_not_found", "no worker found with ID %s", workerID)
+	}
+	slots = append([]AvailabilitySlot(nil), slots...)
+	sortSlots(slots)
+	s.availability[workerID] = slots
+
+	return nil
+}
+
+func (s *MemoryStore) CreateTimeOff(ctx context.Context, t *TimeOff) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findWorker(func(w *Worker) bool { return w.ID == t.WorkerID }) == nil {
+		return NotFound("worker_not_found", "no worker found with ID %s", t.WorkerID)
+	}
+	t.ID = id
+	t.CreatedAt = time.Now().UTC()
+	timeOff := *t
+	s.timeOff = append(s.timeOff, &timeOff)
+
+	return nil
+}
+
+func (s *MemoryStore) GetTimeOff(ctx context.Context, q *TimeOffQuery) ([]*TimeOff, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	timeOff := []*TimeOff{}
+	for _, t := range s.timeOff {
+		if (q.WorkerID == "" || t.WorkerID == q.WorkerID) && (q.Status == "" || t.Status == q.Status) {
+			found := *t
+			timeOff = append(timeOff, &found)
+		}
+	}
+
+	sort.SliceStable(timeOff, func(i, j int) bool {
+		if !timeOff[i].Start.Equal(timeOff[j].Start) {
+			return timeOff[i].Start.Before(timeOff[j].Start)
+		}
+		return timeOff[i].ID < timeOff[j].ID
+	})
+	return timeOff, nil
+}
+
+func (s *MemoryStore) ReviewTimeOff(ctx context.Context, id string, status TimeOffStatus, reviewedBy string) (*TimeOff, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.timeOff {
+		if t.ID != id {
+			continue
+		}
+		if t.Status != TimeOffPending {
+			return nil, ErrTimeOffReviewed
+		}
+
+		now := time.Now().UTC()
+		t.Status = status
+		t.ReviewedBy = reviewedBy
+		t.ReviewedAt = &now
+
+		timeOff := *t
+		return &timeOff, nil
+	}
+
+	return nil, NotFound("time_off_not_found", "time off %s not found", id)
+}
+
+func (s *MemoryStore) GetAvailableWorkers(ctx context.Context, q *AvailabilityQuery) ([]*Worker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// An ephemeral command spanning the window finds the overlapping jobs
+	window := &Command{MoveDate: &q.From, DurationMinutes: int(q.To.Sub(q.From) / time.Minute), Status: StatusScheduled}
+
+	workers := []*Worker{}
+	for _, w := range s.workers {
+		if !w.IsAccepted || (q.Position != "" && w.Position != q.Position) {
+			continue
+		}
+		if s.hasTimeOff(w.ID, q.From, q.To) || s.overlappingJob(w.ID, window) != "" {
+			continue
+		}
+		if !coversWindow(s.availability[w.ID], q.From, q.To) {
+			continue
+		}
+		worker := *w
+		workers = append(workers, &worker)
+	}
+
+	sort.SliceStable(workers, func(i, j int) bool {
+		if workers[i].FullName != workers[j].FullName {
+			return workers[i].FullName < workers[j].FullName
+		}
+		return workers[i].ID < workers[j].ID
+	})
+	return workers, nil
+}
+
+func (s *MemoryStore) GetRateCard(ctx context.Context) (*RateCard, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.rateCards) == 0 {
+		return nil, NotFound("rate_card_not_found", "no rate card has been saved")
+	}
+	return s.rateCards[len(s.rateCards)-1].clone(), nil
+}
+
+func (s *MemoryStore) SaveRateCard(ctx context.Context, card *RateCard) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	card.ID = int64(len(s.rateCards) + 1)
+	card.CreatedAt = time.Now().UTC()
+	s.rateCards = append(s.rateCards, card.clone())
+
+	return nil
+}
+
+func (s *MemoryStore) GetLoginThrottle(ctx context.Context, scope ThrottleScope, key string) (*LoginThrottle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if t := s.findLoginThrottle(scope, key); t != nil {
+		throttle := *t
+		return &throttle, nil
+	}
+
+	return nil, ErrThrottleNotFound
+}
+
+func (s *MemoryStore) GetLoginThrottles(ctx context.Context) ([]*LoginThrottle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	throttles := []*LoginThrottle{}
+	for _, t := range s.loginThrottles {
+		if recentLoginFailure(t) {
+			throttle := *t
+			throttles = append(throttles, &throttle)
+		}
+	}
+	sort.SliceStable(throttles, func(i, j int) bool {
+		return throttles[i].LastFailureAt.After(throttles[j].LastFailureAt)
+	})
+
+	return throttles, nil
+}
+
+func (s *MemoryStore) ReserveLoginAttempt(ctx context.Context, scope ThrottleScope, key string) (*LoginThrottle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteLoginThrottles(func(t *LoginThrottle) bool { return !recentLoginFailure(t) })
+	t := s.findLoginThrottle(scope, key)
+	if t == nil {
+		t = &LoginThrottle{Scope: scope, Key: key}
+		s.loginThrottles = append(s.loginThrottles, t)
+	}
+
+	throttle := *t
+	if throttle.setLock(time.Now()); throttle.LockedUntil != nil {
+		return &throttle, nil
+	}
+	t.Failures++
+	t.LastFailureAt = time.Now().UTC()
+
+	throttle = *t
+	return &throttle, nil
+}
+
+func (s *MemoryStore) ReleaseLoginAttempt(ctx context.Context, scope ThrottleScope, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t := s.findLoginThrottle(scope, key); t != nil {
+		t.Failures--
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClearLoginThrottle(ctx context.Context, scope ThrottleScope, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findLoginThrottle(scope, key) == nil {
+		return ErrThrottleNotFound
+	}
+	s.deleteLoginThrottles(func(t *LoginThrottle) bool { return t.Scope == scope && t.Key == key })
+
+	return nil
+}
+
+// recentLoginFailure reports whether t has failures, the last still
+// remembered
+func recentLoginFailure(t *LoginThrottle) bool {
+	return t.Failures > 0 && time.Since(t.LastFailureAt) < loginFailureWindow
+}
+
+// findLoginThrottle returns the remembered failures of scope and key, nil
+// if there are none; callers must hold s.mu
+func (s *MemoryStore) findLoginThrottle(scope ThrottleScope, key string) *LoginThrottle {
+	for _, t := range s.loginThrottles {
+		if t.Scope == scope && t.Key == key && recentLoginFailure(t) {
+			return t
+		}
+	}
+	return nil
+}
+
+// deleteLoginThrottles removes the throttles matching fn; callers must
+// hold s.mu
+func (s *MemoryStore) deleteLoginThrottles(fn func(*LoginThrottle) bool) {
+	throttles := s.loginThrottles[:0]
+	for _, t := range s.loginThrottles {
+		if !fn(t) {
+			throttles = append(throttles, t)
+		}
+	}
+	s.loginThrottles = throttles
+}
+
+func (s *MemoryStore) GetTOTP(ctx context.Context, workerID string) (*TOTP, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.totp[workerID]
+	if !ok {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	totp := *t
+	for _, code := range s.recoveryCodes {
+		if code.workerID == workerID && !code.used {
+			totp.RecoveryCodesLeft++
+		}
+	}
+
+	return &totp, nil
+}
+
+func (s *MemoryStore) SaveTOTP(ctx context.Context, totp *TOTP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findWorker(func(w *Worker) bool { return w.ID == totp.WorkerID }) == nil {
+		return NotFound("worker_not_found", "no worker found with ID %s", totp.WorkerID)
+	}
+	if t, ok := s.totp[totp.WorkerID]; ok && t.ConfirmedAt != nil {
+		return ErrTwoFactorEnabled
+	}
+
+	totp.ConfirmedAt = nil
+	totp.LastStep = 0
+	totp.CreatedAt = time.Now().UTC()
+	t := *totp
+	s.totp[totp.WorkerID] = &t
+
+	return nil
+}
+
+func (s *MemoryStore) ConfirmTOTP(ctx context.Context, workerID string, step int64, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totp[workerID]
+	if !ok {
+		return ErrTwoFactorNotEnrolled
+	}
+	if t.ConfirmedAt != nil {
+		return ErrTwoFactorEnabled
+	}
+
+	now := time.Now().UTC()
+	t.ConfirmedAt = &now
+	t.LastStep = step
+	s.deleteRecoveryCodes(workerID)
+	for _, hash := range recoveryCodeHashes {
+		s.recoveryCodes = append(s.recoveryCodes, &recoveryCode{workerID: workerID, hash: hash})
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) UseTOTPStep(ctx context.Context, workerID string, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totp[workerID]
+	if !ok || t.ConfirmedAt == nil || t.LastStep >= step {
+		return ErrInvalidTwoFactorCode
+	}
+	t.LastStep = step
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteTOTP(ctx context.Context, workerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.totp[workerID]; !ok {
+		return ErrTwoFactorNotEnrolled
+	}
+	delete(s.totp, workerID)
+	s.deleteRecoveryCodes(workerID)
+
+	return nil
+}
+
+func (s *MemoryStore) ReplaceRecoveryCodes(ctx context.Context, workerID string, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.totp[workerID]; !ok || t.ConfirmedAt == nil {
+		return ErrTwoFactorNotEnrolled
+	}
+	s.deleteRecoveryCodes(workerID)
+	for _, hash := range hashes {
+		s.recoveryCodes = append(s.recoveryCodes, &recoveryCode{workerID: workerID, hash: hash})
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(ctx context.Context, workerID, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, code := range s.recoveryCodes {
+		if code.workerID == workerID && code.hash == hash && !code.used {
+			code.used = true
+			return nil
+		}
+	}
+
+	return ErrInvalidTwoFactorCode
+}
+
+// deleteRecoveryCodes removes a worker's recovery codes; callers must hold
+// s.mu
+func (s *MemoryStore) deleteRecoveryCodes(workerID string) {
+	codes := s.recoveryCodes[:0]
+	for _, code := range s.recoveryCodes {
+		if code.workerID != workerID {
+			codes = append(codes, code)
+		}
+	}
+	s.recoveryCodes = codes
+}
+
+func (s *MemoryStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.ID = id
+	token.CreatedAt = time.Now().UTC()
+	t := *token
+	s.sessions = append(s.sessions, &t)
+
+	return nil
+}
+
+func (s *MemoryStore) GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, t := range s.sessions {
+		if t.TokenHash == hash {
+			token := *t
+			return &token, nil
+		}
+	}
+
+	return nil, ErrInvalidRefreshToken
+}
+
+func (s *MemoryStore) RotateRefreshToken(ctx context.Context, hash string, next *RefreshToken) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current *RefreshToken
+	for _, t := range s.sessions {
+		if t.TokenHash == hash {
+			current = t
+		}
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case current == nil, current.RevokedAt != nil:
+		return ErrInvalidRefreshToken
+	case current.UsedAt != nil:
+		s.revokeSessions(func(t *RefreshToken) bool { return t.FamilyID == current.FamilyID })
+		return ErrRefreshTokenReused
+	case now.After(current.ExpiresAt):
+		return ErrInvalidRefreshToken
+	}
+
+	current.UsedAt = &now
+	next.ID = id
+	next.FamilyID = current.FamilyID
+	next.WorkerID = current.WorkerID
+	next.CreatedAt = now
+	t := *next
+	s.sessions = append(s.sessions, &t)
+
+	return nil
+}
+
+func (s *MemoryStore) RevokeSession(ctx context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeSessions(func(t *RefreshToken) bool { return t.FamilyID == familyID })
+	return nil
+}
+
+func (s *MemoryStore) RevokeWorkerSessions(ctx context.Context, workerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeSessions(func(t *RefreshToken) bool { return t.WorkerID == workerID })
+	return nil
+}
+
+func (s *MemoryStore) IsSessionActive(ctx context.Context, familyID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	for _, t := range s.sessions {
+		if t.FamilyID == familyID && t.RevokedAt == nil && now.Before(t.ExpiresAt) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *MemoryStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.apiKeys {
+		if k.Prefix == key.Prefix {
+			return Conflict("already_exists", "api key prefix %s already exists", key.Prefix)
+		}
+	}
+
+	key.ID = id
+	key.CreatedAt = time.Now().UTC()
+	k := *key
+	k.Scopes = append([]Scope(nil), key.Scopes...)
+	s.apiKeys = append(s.apiKeys, &k)
+
+	return nil
+}
+
+func (s *MemoryStore) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, k := range s.apiKeys {
+		if k.Prefix == prefix {
+			key := *k
+			return &key, nil
+		}
+	}
+
+	return nil, NotFound("api_key_not_found", "api key %s not found", prefix)
+}
+
+func (s *MemoryStore) GetAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []*APIKey{}
+	for _, k := range s.apiKeys {
+		key := *k
+		keys = append(keys, &key)
+	}
+
+	return keys, nil
+}
+
+func (s *MemoryStore) RevokeAPIKey(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.apiKeys {
+		if k.ID == id && k.RevokedAt == nil {
+			now := time.Now().UTC()
+			k.RevokedAt = &now
+			return nil
+		}
+	}
+
+	return NotFound("api_key_not_found", "no active api key found with ID %s", id)
+}
+
+func (s *MemoryStore) TouchAPIKey(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.apiKeys {
+		if k.ID == id {
+			now := time.Now().UTC()
+			k.LastUsedAt = &now
+		}
+	}
+
+	return nil
+}
+
+// revokeSessions revokes the live refresh tokens matching fn; callers must
+// hold s.mu
+func (s *MemoryStore) revokeSessions(fn func(*RefreshToken) bool) {
+	now := time.Now().UTC()
+	for _, t := range s.sessions {
+		if t.RevokedAt == nil && fn(t) {
+			t.RevokedAt = &now
+		}
+	}
+}
+
+// deleteHistory removes the status history of a command, like the
+// ON DELETE CASCADE on command_status_history; callers must hold s.mu
+func (s *MemoryStore) deleteHistory(commandID string) {
+	history := s.history[:0]
+	for _, h := range s.history {
+		if h.CommandID != commandID {
+			history = append(history, h)
+		}
+	}
+	s.history = history
+}
+
+// deleteAssignments removes the assignments matching fn, like the
+// ON DELETE CASCADE on command_assignments; callers must hold s.mu
+func (s *MemoryStore) deleteAssignments(fn func(*Assignment) bool) {
+	assignments := s.assignments[:0]
+	for _, a := range s.assignments {
+		if !fn(a) {
+			assignments = append(assignments, a)
+		}
+	}
+	s.assignments = assignments
+}
+
+// deleteTimeOff removes a worker's time off; callers must hold s.mu
+func (s *MemoryStore) deleteTimeOff(workerID string) {
+	timeOff := s.timeOff[:0]
+	for _, t := range s.timeOff {
+		if t.WorkerID != workerID {
+			timeOff = append(timeOff, t)
+		}
+	}
+	s.timeOff = timeOff
+}
+
+// deleteApplication removes the review trail of a worker's application;
+// callers must hold s.mu
+func (s *MemoryStore) deleteApplication(workerID string) {
+	history := s.applicationHistory[:0]
+	for _, h := range s.applicationHistory {
+		if h.WorkerID != workerID {
+			history = append(history, h)
+		}
+	}
+	s.applicationHistory = history
+
+	notes := s.applicationNotes[:0]
+	for _, n := range s.applicationNotes {
+		if n.WorkerID != workerID {
+			notes = append(notes, n)
+		}
+	}
+	s.applicationNotes = notes
+}
+
+// deleteInvitations removes the invitations matching fn; callers must hold
+// s.mu
+func (s *MemoryStore) deleteInvitations(fn func(*Invitation) bool) {
+	invitations := s.invitations[:0]
+	for _, inv := range s.invitations {
+		if !fn(inv) {
+			invitations = append(invitations, inv)
+		}
+	}
+	s.invitations = invitations
+}
+
+// deletePasswordResets removes a worker's reset tokens, only the unused
+// ones if unusedOnly; callers must hold s.mu
+func (s *MemoryStore) deletePasswordResets(workerID string, unusedOnly bool) {
+	resets := s.passwordResets[:0]
+	for _, reset := range s.passwordResets {
+		if reset.WorkerID != workerID || (unusedOnly && reset.UsedAt != nil) {
+			resets = append(resets, reset)
+		}
+	}
+	s.passwordResets = resets
+}
+
+// findCommand returns the command with id, or nil; callers must hold s.mu
+func (s *MemoryStore) findCommand(id string) *Command {
+	for _, c := range s.commands {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// findWorker returns the first worker matching fn; callers must hold s.mu
+func (s *MemoryStore) findWorker(fn func(*Worker) bool) *Worker {
+	for _, w := range s.workers {
+		if fn(w) {
+			return w
+		}
+	}
+	return nil
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID, matching gen_random_uuid()
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// Close does nothing: the data lives as long as the process
+func (s *MemoryStore) Close() error {
+	return nil
+}
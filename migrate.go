@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the pg_advisory_lock key that serialises migration
+// runs, so replicas starting at the same time don't race each other
+const migrationLockID int64 = 4_178_221_903
+
+// Migration is one ordered schema change with its rollback step.
+// Checksum covers both steps, so a down step edited after the migration
+// was applied is noticed before a rollback runs it.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+	// upChecksum is the checksum of the up step alone, which is what was
+	// recorded before Checksum covered both
+	upChecksum string
+}
+
+type appliedMigration struct {
+	Version  int
+	Name     string
+	Checksum string
+}
+
+// loadMigrations reads the embedded NNNN_name.up.sql / NNNN_name.down.sql
+// files and returns them ordered by version
+func loadMigrations() ([]*Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		file := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql suffix", file)
+		}
+
+		base := strings.TrimSuffix(file, "."+direction+".sql")
+		prefix, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name prefix", file)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", file, err)
+		}
+
+		body, err := migrationFiles.ReadFile(path.Join("migrations", file))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no up step", m.Version, m.Name)
+		}
+		m.Checksum, m.upChecksum = migrationChecksums(m.Up, m.Down)
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// migrationChecksums returns the checksum of a migration's steps, hashing
+// each step's hash so that no two pairs of steps hash alike, and that of
+// the up step alone
+func migrationChecksums(up, down string) (checksum, upChecksum string) {
+	upSum := sha256.Sum256([]byte(up))
+	downSum := sha256.Sum256([]byte(down))
+	sum := sha256.Sum256(append(upSum[:], downSum[:]...))
+	return hex.EncodeToString(sum[:]), hex.EncodeToString(upSum[:])
+}
+
+// Migrate applies every pending migration in order
+func (s *PostgresStore) Migrate() error {
+	return s.withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		migrations, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if a, ok := applied[m.Version]; ok {
+				if a.Checksum == m.upChecksum {
+					// Recorded before checksums covered the down step,
+					// which is taken as it is now
+					if _, err := conn.ExecContext(ctx, `UPDATE schema_migrations SET checksum = $1 WHERE version = $2`, m.Checksum, m.Version); err != nil {
+						return err
+					}
+					continue
+				}
+				if a.Checksum != m.Checksum {
+					return fmt.Errorf("migration %d (%s) has been modified since it was applied", m.Version, m.Name)
+				}
+				continue
+			}
+
+			if err := runMigration(ctx, conn, m.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx,
+					`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+					m.Version, m.Name, m.Checksum,
+				)
+				return err
+			}); err != nil {
+				return fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Name, err)
+			}
+			log.Printf("Applied migration %d (%s)\n", m.Version, m.Name)
+		}
+
+		return nil
+	})
+}
+
+// MigrateDown rolls back the last steps applied migrations
+func (s *PostgresStore) MigrateDown(steps int) error {
+	return s.withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		migrations, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+		byVersion := map[int]*Migration{}
+		for _, m := range migrations {
+			byVersion[m.Version] = m
+		}
+
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		for i := 0; i < steps && i < len(versions); i++ {
+			m, ok := byVersion[versions[i]]
+			if !ok {
+				return fmt.Errorf("migration %d is applied but has no source", versions[i])
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d (%s) has no down step", m.Version, m.Name)
+			}
+			if a := applied[m.Version]; a.Checksum != m.Checksum && a.Checksum != m.upChecksum {
+				return fmt.Errorf("migration %d (%s) has been modified since it was applied", m.Version, m.Name)
+			}
+
+			if err := runMigration(ctx, conn, m.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("failed to roll back migration %d (%s): %w", m.Version, m.Name, err)
+			}
+			log.Printf("Rolled back migration %d (%s)\n", m.Version, m.Name)
+		}
+
+		return nil
+	})
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// advisory lock, creating the schema_migrations table if needed
+func (s *PostgresStore) withMigrationLock(fn func(context.Context, *sql.Conn) error) error {
+	ctx := context.Background()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	return fn(ctx, conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum); err != nil {
+			return nil, err
+		}
+		applied[a.Version] = a
+	}
+
+	return applied, rows.Err()
+}
+
+// runMigration executes script and record in one transaction
+func runMigration(ctx context.Context, conn *sql.Conn, script string, record func(*sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
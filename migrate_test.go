@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d (%s) at position %d, want versions without gaps", m.Version, m.Name, i)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d (%s) has no down step", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrationChecksumsCoverBothSteps(t *testing.T) {
+	checksum, upChecksum := migrationChecksums("CREATE TABLE t ();", "DROP TABLE t;")
+
+	editedDown, editedUpChecksum := migrationChecksums("CREATE TABLE t ();", "DROP TABLE t CASCADE;")
+	if editedDown == checksum {
+		t.Error("editing the down step kept the checksum")
+	}
+	if editedUpChecksum != upChecksum {
+		t.Error("editing the down step changed the up checksum")
+	}
+
+	if moved, _ := migrationChecksums("CREATE TABLE t ();DROP", " TABLE t;"); moved == checksum {
+		t.Error("moving text between the steps kept the checksum")
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS worker;
+DROP TABLE IF EXISTS commandsss;
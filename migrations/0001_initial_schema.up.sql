@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS commandsss (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	fullname varchar(100) NOT NULL,
+	number varchar(100) NOT NULL,
+	flor varchar(100) NOT NULL,
+	itemtype varchar(100) NOT NULL,
+	services varchar(100) NOT NULL,
+	workers varchar(100) NOT NULL,
+	start varchar(100) NOT NULL,
+	distination varchar(100) NOT NULL,
+	isaccepted varchar(100) NOT NULL,
+	prix varchar(100) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS worker (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	fullname VARCHAR(100) NOT NULL,
+	number VARCHAR(20) NOT NULL,
+	email VARCHAR(100) NOT NULL UNIQUE,
+	password VARCHAR(100) NOT NULL,
+	position VARCHAR(100) NOT NULL,
+	experience TEXT NOT NULL,
+	message TEXT NOT NULL,
+	isaccepted BOOLEAN NOT NULL
+);
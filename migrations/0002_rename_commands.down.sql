@@ -0,0 +1 @@
+ALTER TABLE commands RENAME TO commandsss;
@@ -0,0 +1 @@
+ALTER TABLE commandsss RENAME TO commands;
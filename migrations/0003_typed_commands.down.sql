@@ -0,0 +1,17 @@
+ALTER TABLE commands
+	DROP CONSTRAINT commands_status_check,
+	ADD COLUMN prix VARCHAR(100) NOT NULL DEFAULT '',
+	ADD COLUMN isaccepted VARCHAR(100) NOT NULL DEFAULT '';
+
+UPDATE commands SET
+	prix = (price_amount / 100.0)::numeric(14, 2)::text,
+	isaccepted = CASE status WHEN 'accepted' THEN 'true' ELSE 'false' END;
+
+ALTER TABLE commands
+	ALTER COLUMN flor TYPE VARCHAR(100) USING flor::text,
+	ALTER COLUMN workers TYPE VARCHAR(100) USING workers::text,
+	DROP COLUMN price_amount,
+	DROP COLUMN price_currency,
+	DROP COLUMN move_date,
+	DROP COLUMN status,
+	DROP COLUMN created_at;
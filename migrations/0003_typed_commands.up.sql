@@ -0,0 +1,45 @@
+-- Refuse to convert if any legacy string value can't be parsed, listing
+-- the offending rows so they can be fixed by hand before retrying.
+DO $$
+DECLARE
+	bad TEXT;
+BEGIN
+	SELECT string_agg(id::text || ' ' || reason, '; ') INTO bad FROM (
+		SELECT id, 'flor=' || quote_literal(flor) AS reason
+		FROM commands WHERE btrim(flor) !~ '^-?[0-9]{1,4}$'
+		UNION ALL
+		SELECT id, 'workers=' || quote_literal(workers)
+		FROM commands WHERE btrim(workers) !~ '^[0-9]{1,4}$'
+		UNION ALL
+		SELECT id, 'prix=' || quote_literal(prix)
+		FROM commands WHERE btrim(prix) !~ '^[0-9]{1,12}([.,][0-9]{1,2})?$'
+	) invalid;
+
+	IF bad IS NOT NULL THEN
+		RAISE EXCEPTION 'commands contain unparsable legacy values: %', bad
+			USING HINT = 'correct these rows, then restart to retry migration 3';
+	END IF;
+END
+$$;
+
+ALTER TABLE commands
+	ALTER COLUMN flor TYPE INTEGER USING btrim(flor)::integer,
+	ALTER COLUMN workers TYPE INTEGER USING btrim(workers)::integer,
+	ADD COLUMN price_amount BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN price_currency CHAR(3) NOT NULL DEFAULT 'DZD',
+	ADD COLUMN move_date TIMESTAMPTZ,
+	ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+UPDATE commands SET
+	price_amount = round(replace(btrim(prix), ',', '.')::numeric * 100)::bigint,
+	status = CASE
+		WHEN lower(btrim(isaccepted)) IN ('true', 't', 'yes', '1', 'accepted') THEN 'accepted'
+		WHEN lower(btrim(isaccepted)) IN ('rejected', 'refused') THEN 'rejected'
+		ELSE 'pending'
+	END;
+
+ALTER TABLE commands
+	DROP COLUMN prix,
+	DROP COLUMN isaccepted,
+	ADD CONSTRAINT commands_status_check CHECK (status IN ('pending', 'accepted', 'rejected'));
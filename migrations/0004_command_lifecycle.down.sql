@@ -0,0 +1,12 @@
+DROP TABLE command_status_history;
+
+UPDATE commands SET status = CASE
+	WHEN status IN ('scheduled', 'in_progress', 'completed') THEN 'accepted'
+	WHEN status = 'cancelled' THEN 'rejected'
+	WHEN status = 'quoted' THEN 'pending'
+	ELSE status
+END;
+
+ALTER TABLE commands
+	DROP CONSTRAINT commands_status_check,
+	ADD CONSTRAINT commands_status_check CHECK (status IN ('pending', 'accepted', 'rejected'));
@@ -0,0 +1,18 @@
+ALTER TABLE commands
+	DROP CONSTRAINT commands_status_check,
+	ADD CONSTRAINT commands_status_check CHECK (status IN (
+		'pending', 'quoted', 'accepted', 'scheduled',
+		'in_progress', 'completed', 'cancelled', 'rejected'
+	));
+
+CREATE TABLE command_status_history (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	command_id UUID NOT NULL REFERENCES commands (id) ON DELETE CASCADE,
+	from_status VARCHAR(20) NOT NULL,
+	to_status VARCHAR(20) NOT NULL,
+	changed_by VARCHAR(100),
+	note TEXT NOT NULL DEFAULT '',
+	changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX command_status_history_command_idx ON command_status_history (command_id, changed_at);
@@ -0,0 +1,5 @@
+DROP INDEX worker_created_at_idx;
+DROP INDEX commands_status_idx;
+DROP INDEX commands_created_at_idx;
+
+ALTER TABLE worker DROP COLUMN created_at;
@@ -0,0 +1,5 @@
+ALTER TABLE worker ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX commands_created_at_idx ON commands (created_at, id);
+CREATE INDEX commands_status_idx ON commands (status);
+CREATE INDEX worker_created_at_idx ON worker (created_at, id);
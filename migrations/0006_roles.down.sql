@@ -0,0 +1 @@
+ALTER TABLE worker DROP COLUMN role;
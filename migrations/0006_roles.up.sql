@@ -0,0 +1,3 @@
+ALTER TABLE worker
+	ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'worker',
+	ADD CONSTRAINT worker_role_check CHECK (role IN ('admin', 'dispatcher', 'worker'));
@@ -0,0 +1,13 @@
+CREATE TABLE refresh_tokens (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	family_id UUID NOT NULL,
+	worker_id UUID NOT NULL REFERENCES worker (id) ON DELETE CASCADE,
+	token_hash CHAR(64) NOT NULL UNIQUE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_worker_idx ON refresh_tokens (worker_id);
@@ -0,0 +1,5 @@
+ALTER TABLE commands
+	DROP COLUMN quote,
+	DROP COLUMN elevator;
+
+DROP TABLE rate_cards;
@@ -0,0 +1,27 @@
+CREATE TABLE rate_cards (
+	id BIGSERIAL PRIMARY KEY,
+	card JSONB NOT NULL,
+	created_by UUID REFERENCES worker (id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- The same card as DefaultRateCard
+INSERT INTO rate_cards (card) VALUES ('{
+	"currency": "DZD",
+	"services": {"moving": 800000, "transport": 500000, "packing": 300000},
+	"itemtypes": {"furniture": 0, "appliances": 150000, "office": 300000, "piano": 500000},
+	"perworker": 200000,
+	"perkm": 5000,
+	"mindistancekm": 5,
+	"perfloorstairs": 100000,
+	"perfloorelevator": 20000,
+	"minimumprice": 1000000,
+	"weekendpercent": 15,
+	"holidaypercent": 25,
+	"weekenddays": ["friday", "saturday"],
+	"holidays": ["01-01", "01-12", "05-01", "07-05", "11-01"]
+}');
+
+ALTER TABLE commands
+	ADD COLUMN elevator BOOLEAN NOT NULL DEFAULT false,
+	ADD COLUMN quote JSONB;
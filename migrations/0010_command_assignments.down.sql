@@ -0,0 +1,4 @@
+DROP TABLE command_assignments;
+
+ALTER TABLE commands
+	DROP COLUMN duration_minutes;
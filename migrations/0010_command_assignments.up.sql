@@ -0,0 +1,15 @@
+ALTER TABLE commands
+	ADD COLUMN duration_minutes INTEGER NOT NULL DEFAULT 240 CHECK (duration_minutes > 0);
+
+CREATE TABLE command_assignments (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	command_id UUID NOT NULL REFERENCES commands (id) ON DELETE CASCADE,
+	worker_id UUID NOT NULL REFERENCES worker (id) ON DELETE CASCADE,
+	role VARCHAR(20) NOT NULL CHECK (role IN ('driver', 'mover', 'lead')),
+	assigned_by UUID REFERENCES worker (id) ON DELETE SET NULL,
+	assigned_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE (command_id, worker_id)
+);
+
+CREATE INDEX command_assignments_worker_idx ON command_assignments (worker_id);
+CREATE UNIQUE INDEX command_assignments_lead_idx ON command_assignments (command_id) WHERE role = 'lead';
@@ -0,0 +1,2 @@
+DROP TABLE time_off;
+DROP TABLE worker_availability;
@@ -0,0 +1,24 @@
+CREATE TABLE worker_availability (
+	worker_id UUID NOT NULL REFERENCES worker (id) ON DELETE CASCADE,
+	weekday SMALLINT NOT NULL CHECK (weekday BETWEEN 0 AND 6),
+	start_minute SMALLINT NOT NULL CHECK (start_minute >= 0),
+	end_minute SMALLINT NOT NULL CHECK (end_minute <= 1440),
+	CHECK (start_minute < end_minute)
+);
+
+CREATE INDEX worker_availability_worker_idx ON worker_availability (worker_id);
+
+CREATE TABLE time_off (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	worker_id UUID NOT NULL REFERENCES worker (id) ON DELETE CASCADE,
+	starts_at TIMESTAMPTZ NOT NULL,
+	ends_at TIMESTAMPTZ NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+	reviewed_by UUID REFERENCES worker (id) ON DELETE SET NULL,
+	reviewed_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	CHECK (starts_at < ends_at)
+);
+
+CREATE INDEX time_off_worker_idx ON time_off (worker_id, starts_at);
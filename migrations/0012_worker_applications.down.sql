@@ -0,0 +1,5 @@
+DROP TABLE application_notes;
+DROP TABLE application_status_history;
+
+ALTER TABLE worker
+	DROP COLUMN application_status;
@@ -0,0 +1,30 @@
+ALTER TABLE worker
+	ADD COLUMN application_status VARCHAR(20) NOT NULL DEFAULT 'submitted'
+		CHECK (application_status IN ('submitted', 'screening', 'interview', 'hired', 'rejected'));
+
+-- Accepted workers were hired before the pipeline existed
+UPDATE worker SET application_status = 'hired' WHERE isaccepted;
+
+CREATE INDEX worker_application_status_idx ON worker (application_status);
+
+CREATE TABLE application_status_history (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	worker_id UUID NOT NULL REFERENCES worker (id) ON DELETE CASCADE,
+	from_status VARCHAR(20) NOT NULL,
+	to_status VARCHAR(20) NOT NULL,
+	changed_by UUID REFERENCES worker (id) ON DELETE SET NULL,
+	note TEXT NOT NULL DEFAULT '',
+	changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX application_status_history_worker_idx ON application_status_history (worker_id, changed_at);
+
+CREATE TABLE application_notes (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	worker_id UUID NOT NULL REFERENCES worker (id) ON DELETE CASCADE,
+	author_id UUID REFERENCES worker (id) ON DELETE SET NULL,
+	body TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX application_notes_worker_idx ON application_notes (worker_id, created_at);
@@ -0,0 +1,5 @@
+DROP TABLE worker_invitations;
+
+-- Workers who never set a password can't log in either way
+UPDATE worker SET password = '' WHERE password IS NULL;
+ALTER TABLE worker ALTER COLUMN password SET NOT NULL;
@@ -0,0 +1,16 @@
+-- Applications are stored without credentials: hired workers set their
+-- password from an invitation
+ALTER TABLE worker ALTER COLUMN password DROP NOT NULL;
+
+UPDATE worker SET password = NULL WHERE application_status <> 'hired';
+
+CREATE TABLE worker_invitations (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	worker_id UUID NOT NULL REFERENCES worker (id) ON DELETE CASCADE,
+	created_by UUID REFERENCES worker (id) ON DELETE SET NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX worker_invitations_worker_idx ON worker_invitations (worker_id);
@@ -0,0 +1,10 @@
+CREATE TABLE password_resets (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	worker_id UUID NOT NULL REFERENCES worker (id) ON DELETE CASCADE,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX password_resets_worker_idx ON password_resets (worker_id);
@@ -0,0 +1,9 @@
+CREATE TABLE login_throttles (
+	scope VARCHAR(10) NOT NULL CHECK (scope IN ('account', 'ip')),
+	key VARCHAR(255) NOT NULL,
+	failures INTEGER NOT NULL CHECK (failures > 0),
+	last_failure_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX login_throttles_last_failure_idx ON login_throttles (last_failure_at);
@@ -0,0 +1,15 @@
+CREATE TABLE worker_totp (
+	worker_id UUID PRIMARY KEY REFERENCES worker (id) ON DELETE CASCADE,
+	secret VARCHAR(64) NOT NULL,
+	confirmed_at TIMESTAMPTZ,
+	last_step BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE totp_recovery_codes (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	worker_id UUID NOT NULL REFERENCES worker (id) ON DELETE CASCADE,
+	code_hash VARCHAR(64) NOT NULL,
+	used_at TIMESTAMPTZ,
+	UNIQUE (worker_id, code_hash)
+);
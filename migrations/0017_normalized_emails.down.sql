@@ -0,0 +1 @@
+ALTER TABLE worker DROP CONSTRAINT worker_email_normalized;
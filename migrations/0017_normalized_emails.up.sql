@@ -0,0 +1,6 @@
+-- Emails are stored trimmed and lower-cased, which makes their UNIQUE
+-- constraint case-insensitive. Accounts differing only by the case of their
+-- email make this fail, and have to be merged by hand first.
+UPDATE worker SET email = lower(btrim(email)) WHERE email <> lower(btrim(email));
+
+ALTER TABLE worker ADD CONSTRAINT worker_email_normalized CHECK (email = lower(btrim(email)));
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// passwordResetTTL is how long a reset link stays valid
+const passwordResetTTL = time.Hour
+
+var ErrInvalidResetToken = BadRequest("invalid_reset_token", "invalid or expired reset link")
+
+// PasswordReset is a pending password reset. Like refresh tokens, only the
+// token's hash is stored; it can be used once, before ExpiresAt.
+type PasswordReset struct {
+	ID        string
+	WorkerID  string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,max=100,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required,max=200"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+// forgotPasswordResponse is the answer to every well-formed request, so it
+// doesn't tell whether an account exists for the email
+var forgotPasswordResponse = map[string]string{
+	"message": "if an account exists for this email, a link to reset its password has been sent",
+}
+
+// handleForgotPassword mails a reset link to active accounts with a
+// password. The account is looked up and mailed in the background, and
+// failures are logged rather than reported, for the response and the time
+// it takes to be the same whatever the email.
+func (s *APIServer) handleForgotPassword(w http.ResponseWriter, r *http.Request) error {
+	req := new(ForgotPasswordRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	s.background(r.Context(), func(ctx context.Context) {
+		if err := s.sendPasswordReset(ctx, req.Email); err != nil {
+			log.Printf("password reset: %v", err)
+		}
+	})
+
+	return WriteJSON(w, http.StatusAccepted, forgotPasswordResponse)
+}
+
+func (s *APIServer) sendPasswordReset(ctx context.Context, email string) error {
+	worker, err := s.store.GetWorkerByEmail(ctx, email)
+	if KindOf(err) == KindNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Applicants activate their account instead, and deactivated
+	// workers can't log in anyway
+	if !worker.IsAccepted || worker.Password == "" {
+		return nil
+	}
+
+	token, reset, err := newPasswordReset(worker.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.store.CreatePasswordReset(ctx, reset); err != nil {
+		return err
+	}
+
+	link := s.links.PasswordReset + "?token=" + url.QueryEscape(token)
+	return s.mailer.Send(&Mail{
+		To:      worker.Email,
+		Subject: "Reset your Krixo password",
+		Body: fmt.Sprintf("Hello %s,\n\nSomeone asked to reset the password of your account. If it was you, choose a new password here:\n\n%s\n\nThe link expires in %d minutes. If you didn't ask, ignore this email: your password is unchanged.\n",
+			worker.FullName, link, int(passwordResetTTL/time.Minute)),
+	})
+}
+
+// newPasswordReset returns a random reset token for the worker and its
+// stored form
+func newPasswordReset(workerID string) (string, *PasswordReset, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &PasswordReset{
+		WorkerID:  workerID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL).UTC(),
+	}, nil
+}
+
+// handleResetPassword sets a new password from a reset token and logs the
+// worker out everywhere
+func (s *APIServer) handleResetPassword(w http.ResponseWriter, r *http.Request) error {
+	req := new(ResetPasswordRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	workerID, err := s.store.ResetPassword(r.Context(), hashToken(req.Token), req.Password)
+	if err != nil {
+		return err
+	}
+	if err := s.store.RevokeWorkerSessions(r.Context(), workerID); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Coordinates is a point on Earth in decimal degrees
+type Coordinates struct {
+	Lat float64
+	Lon float64
+}
+
+// distanceKm is the great-circle distance between c and o
+func (c Coordinates) distanceKm(o Coordinates) float64 {
+	const earthRadiusKm = 6371
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := rad(o.Lat - c.Lat)
+	dLon := rad(o.Lon - c.Lon)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(c.Lat))*math.Cos(rad(o.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Locator finds where a free-text address is, for pricing the distance of
+// a move. It reports false for addresses it can't place.
+type Locator interface {
+	Locate(address string) (Coordinates, bool)
+}
+
+// cityLocator places addresses by the city they mention, which is as
+// precise as quotes need to be
+type cityLocator map[string]Coordinates
+
+// algerianCities are the cities served, keyed by their normalized names
+// and common spellings
+var algerianCities = cityLocator{
+	"alger":              {36.7538, 3.0588},
+	"algiers":            {36.7538, 3.0588},
+	"oran":               {35.6971, -0.6308},
+	"constantine":        {36.3650, 6.6147},
+	"annaba":             {36.9000, 7.7667},
+	"blida":              {36.4700, 2.8277},
+	"batna":              {35.5550, 6.1741},
+	"setif":              {36.1911, 5.4137},
+	"sidi bel abbes":     {35.1899, -0.6300},
+	"biskra":             {34.8500, 5.7333},
+	"tebessa":            {35.4042, 8.1242},
+	"tlemcen":            {34.8783, -1.3150},
+	"bejaia":             {36.7509, 5.0567},
+	"bougie":             {36.7509, 5.0567},
+	"tizi ouzou":         {36.7169, 4.0497},
+	"boumerdes":          {36.7664, 3.4772},
+	"tipaza":             {36.5897, 2.4475},
+	"medea":              {36.2642, 2.7539},
+	"chlef":              {36.1650, 1.3345},
+	"mostaganem":         {35.9312, 0.0892},
+	"skikda":             {36.8762, 6.9092},
+	"jijel":              {36.8200, 5.7667},
+	"bouira":             {36.3800, 3.9000},
+	"djelfa":             {34.6700, 3.2500},
+	"ghardaia":           {32.4900, 3.6700},
+	"ouargla":            {31.9500, 5.3167},
+	"bechar":             {31.6167, -2.2167},
+	"tiaret":             {35.3711, 1.3170},
+	"bordj bou arreridj": {36.0731, 4.7611},
+	"msila":              {35.7058, 4.5419},
+	"mascara":            {35.3967, 0.1403},
+	"guelma":             {36.4621, 7.4261},
+}
+
+// Locate finds the longest city name among the words of address, so
+// "Sidi Bel Abbes" isn't taken for another city it contains
+func (l cityLocator) Locate(address string) (Coordinates, bool) {
+	padded := " " + normalizePlace(address) + " "
+
+	var found string
+	for name := range l {
+		if len(name) > len(found) && strings.Contains(padded, " "+name+" ") {
+			found = name
+		}
+	}
+	if found == "" {
+		return Coordinates{}, false
+	}
+	return l[found], true
+}
+
+// placeFolds strips the accents of the French spellings of city names
+var placeFolds = strings.NewReplacer(
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"à", "a", "â", "a", "î", "i", "ï", "i",
+	"ô", "o", "û", "u", "ù", "u", "ç", "c",
+)
+
+// normalizePlace lowercases s, strips accents and apostrophes and turns
+// any other punctuation into single spaces
+func normalizePlace(s string) string {
+	s = placeFolds.Replace(strings.ToLower(s))
+	s = strings.NewReplacer("'", "", "’", "").Replace(s)
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
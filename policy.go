@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// Action is what a caller wants to do to a resource
+type Action string
+
+const (
+	ActionRead   Action = "read"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	// ActionManage is changing what only admins decide about a resource,
+	// such as whether an account is accepted
+	ActionManage Action = "manage"
+)
+
+// ResourceKind is a kind of resource the policy has rules for
+type ResourceKind string
+
+const (
+	ResourceAccount ResourceKind = "account"
+	ResourceCommand ResourceKind = "command"
+	// ResourceCrew is the assignments of a command, named by its id
+	ResourceCrew ResourceKind = "crew"
+)
+
+// Subject is the caller a decision is made for
+type Subject struct {
+	ID     string
+	Role   Role
+	APIKey *APIKey
+}
+
+func (sub *Subject) String() string {
+	if sub.APIKey != nil {
+		return "api key " + sub.APIKey.Prefix
+	}
+	return string(sub.Role) + " " + sub.ID
+}
+
+// subjectOf returns the caller withJWTAuth authenticated
+func subjectOf(r *http.Request) *Subject {
+	return &Subject{ID: currentUserID(r), Role: currentRole(r), APIKey: currentAPIKey(r)}
+}
+
+// condition decides whether a rule applies to a subject and the resource
+// with id. Errors of kind not found deny, not to tell what exists.
+type condition func(ctx context.Context, s Storage, sub *Subject, id string) (bool, error)
+
+// Rule allows roles, or API keys granted one of scopes, the actions on a
+// kind of resource, when its condition holds or it has none
+type Rule struct {
+	Resource ResourceKind
+	Actions  []Action
+	Roles    []Role
+	Scopes   []Scope
+	When     condition
+}
+
+var allActions = []Action{ActionRead, ActionUpdate, ActionDelete, ActionManage}
+
+// policyRules are every object-level permission: anything they don't
+// allow is denied
+var policyRules = []Rule{
+	{Resource: ResourceAccount, Actions: allActions, Roles: []Role{RoleAdmin}},
+	{Resource: ResourceAccount, Actions: []Action{ActionRead, ActionUpdate}, Roles: []Role{RoleDispatcher, RoleWorker}, When: isSelf},
+	{Resource: ResourceAccount, Actions: []Action{ActionRead}, Roles: []Role{RoleDispatcher}, When: isAssignedWorker},
+
+	{Resource: ResourceCommand, Actions: allActions, Roles: []Role{RoleAdmin}},
+	{Resource: ResourceCommand, Actions: []Action{ActionRead, ActionUpdate}, Roles: []Role{RoleDispatcher}},
+	{Resource: ResourceCommand, Actions: []Action{ActionRead}, Roles: []Role{RoleWorker}, When: isOnCrew},
+
+	{Resource: ResourceCrew, Actions: []Action{ActionRead, ActionUpdate}, Roles: []Role{RoleAdmin, RoleDispatcher}},
+	{Resource: ResourceCrew, Actions: []Action{ActionRead}, Roles: []Role{RoleWorker}, When: isOnCrew},
+
+	// API keys read and update what their scopes cover, and never manage
+	{Resource: ResourceAccount, Actions: []Action{ActionRead}, Scopes: []Scope{ScopeWorkersRead}},
+	{Resource: ResourceAccount, Actions: []Action{ActionUpdate}, Scopes: []Scope{ScopeWorkersWrite}},
+	{Resource: ResourceCommand, Actions: []Action{ActionRead}, Scopes: []Scope{ScopeCommandsRead}},
+	{Resource: ResourceCommand, Actions: []Action{ActionUpdate}, Scopes: []Scope{ScopeCommandsWrite}},
+	{Resource: ResourceCrew, Actions: []Action{ActionRead}, Scopes: []Scope{ScopeCommandsRead}},
+	{Resource: ResourceCrew, Actions: []Action{ActionUpdate}, Scopes: []Scope{ScopeCommandsWrite}},
+}
+
+// isSelf holds for a subject's own account
+func isSelf(ctx context.Context, s Storage, sub *Subject, id string) (bool, error) {
+	return sub.ID != "" && sub.ID == id, nil
+}
+
+// isAssignedWorker holds for accounts on the crew of a command still to be
+// done
+func isAssignedWorker(ctx context.Context, s Storage, sub *Subject, id string) (bool, error) {
+	jobs, err := s.GetWorkerJobs(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	for _, job := range jobs {
+		if job.Command.busy() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isOnCrew holds for commands the subject is assigned to
+func isOnCrew(ctx context.Context, s Storage, sub *Subject, id string) (bool, error) {
+	assignments, err := s.GetAssignments(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range assignments {
+		if a.WorkerID == sub.ID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// authorize decides whether sub may do action to the resource with id,
+// returning ErrPermissionDenied and logging the decision if not
+func authorize(ctx context.Context, s Storage, sub *Subject, action Action, resource ResourceKind, id string) error {
+	for _, rule := range policyRules {
+		if !rule.allows(sub, action, resource) {
+			continue
+		}
+		if rule.When == nil {
+			return nil
+		}
+		ok, err := rule.When(ctx, s, sub, id)
+		if err != nil && KindOf(err) != KindNotFound {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	log.Printf("policy: denied %s to %s %s %s", sub, action, resource, id)
+	return ErrPermissionDenied
+}
+
+// allows reports whether the rule is about the resource and action and
+// applies to sub, leaving its condition aside
+func (rule *Rule) allows(sub *Subject, action Action, resource ResourceKind) bool {
+	if rule.Resource != resource || !contains(rule.Actions, action) {
+		return false
+	}
+	if sub.APIKey != nil {
+		for _, scope := range rule.Scopes {
+			if sub.APIKey.HasScope(scope) {
+				return true
+			}
+		}
+		return false
+	}
+	return contains(rule.Roles, sub.Role)
+}
+
+func contains[T comparable](items []T, item T) bool {
+	for _, it := range items {
+		if it == item {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize decides for the caller of r, see the function of the same name
+func (s *APIServer) authorize(r *http.Request, action Action, resource ResourceKind, id string) error {
+	return authorize(r.Context(), s.store, subjectOf(r), action, resource, id)
+}
+
+// can serves f only if the caller may do action to the resource named by
+// the {id} of the path
+func (s *APIServer) can(action Action, resource ResourceKind, f apiFunc) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		id, err := getID(r)
+		if err != nil {
+			return err
+		}
+		if err := s.authorize(r, action, resource, id); err != nil {
+			return err
+		}
+		return f(w, r)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// apiKey returns a credential for a new API key granted scopes, sent the
+// way the session cookie is
+func (a *workerAPI) apiKey(scopes ...Scope) *http.Cookie {
+	a.t.Helper()
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if err := a.store.CreateAPIKey(context.Background(), &APIKey{Name: "test", Prefix: prefix, KeyHash: hashToken(key), Scopes: scopes}); err != nil {
+		a.t.Fatal(err)
+	}
+	a.secrets = append(a.secrets, key, hashToken(key))
+	return &http.Cookie{Name: accessTokenCookie, Value: key}
+}
+
+func TestAuthorizeAPIKeys(t *testing.T) {
+	const id = "7f1c1a52-3f0e-4c55-9d1e-2b8f4a6c9e01"
+	all := &APIKey{Prefix: "all", Scopes: []Scope{ScopeCommandsRead, ScopeCommandsWrite, ScopeWorkersRead, ScopeWorkersWrite}}
+	readOnly := &APIKey{Prefix: "read", Scopes: []Scope{ScopeCommandsRead, ScopeWorkersRead}}
+
+	tests := []struct {
+		key      *APIKey
+		action   Action
+		resource ResourceKind
+		ok       bool
+	}{
+		{all, ActionRead, ResourceAccount, true},
+		{all, ActionUpdate, ResourceAccount, true},
+		{all, ActionManage, ResourceAccount, false},
+		{all, ActionDelete, ResourceAccount, false},
+		{all, ActionUpdate, ResourceCommand, true},
+		{all, ActionManage, ResourceCommand, false},
+		{all, ActionDelete, ResourceCommand, false},
+		{all, ActionUpdate, ResourceCrew, true},
+		{readOnly, ActionRead, ResourceAccount, true},
+		{readOnly, ActionUpdate, ResourceAccount, false},
+		{readOnly, ActionRead, ResourceCommand, true},
+		{readOnly, ActionUpdate, ResourceCommand, false},
+		{readOnly, ActionRead, ResourceCrew, true},
+		{readOnly, ActionUpdate, ResourceCrew, false},
+	}
+	for _, tt := range tests {
+		err := authorize(context.Background(), NewMemoryStore(), &Subject{APIKey: tt.key}, tt.action, tt.resource, id)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("authorize(%s key, %s, %s) error = %v, want allowed %v", tt.key.Prefix, tt.action, tt.resource, err, tt.ok)
+		}
+	}
+}
+
+func TestAPIKeyCannotManageAccounts(t *testing.T) {
+	a := newWorkerAPI(t)
+	worker := a.createAccount("worker@krixo.test", RoleWorker)
+	key := a.apiKey(ScopeWorkersRead, ScopeWorkersWrite)
+
+	accepted := false
+	if resp, body := a.do(http.MethodPatch, apiV1+"/workers/"+worker.ID, key, &WorkerPatch{IsAccepted: &accepted}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("PATCH isaccepted with an API key = %d %s, want 403", resp.StatusCode, body)
+	}
+	if resp, body := a.do(http.MethodPost, "/UpdateWorker", key, map[string]any{"id": worker.ID, "isaccepted": false}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("legacy isaccepted update with an API key = %d %s, want 403", resp.StatusCode, body)
+	}
+	got, err := a.store.GetAccountByID(context.Background(), worker.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsAccepted {
+		t.Error("the API key deactivated the worker")
+	}
+
+	name := "Renamed by a key"
+	if resp, body := a.do(http.MethodPatch, apiV1+"/workers/"+worker.ID, key, &WorkerPatch{FullName: &name}); resp.StatusCode != http.StatusOK {
+		t.Errorf("PATCH fullname with an API key = %d %s, want 200", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RateCard holds the prices quotes are computed from. Amounts are in minor
+// units of Currency. Cards are versioned: saving one adds a new version and
+// quotes record the version they were computed with.
+type RateCard struct {
+	ID        int64     `json:"id"`
+	CreatedBy string    `json:"createdby"`
+	CreatedAt time.Time `json:"createdat"`
+
+	Currency string `json:"currency" validate:"required,currency"`
+	// Services and Itemtypes price the orders by kind; a service or item
+	// type missing from the card can't be quoted
+	Services  map[string]int64 `json:"services" validate:"required"`
+	Itemtypes map[string]int64 `json:"itemtypes" validate:"required"`
+	PerWorker int64            `json:"perworker" validate:"min=0"`
+	PerKm     int64            `json:"perkm" validate:"min=0"`
+	// MinDistanceKm is charged for moves within a city
+	MinDistanceKm int64 `json:"mindistancekm" validate:"min=0"`
+	// Per floor above or below the ground floor, stairs being dearer
+	PerFloorStairs   int64 `json:"perfloorstairs" validate:"min=0"`
+	PerFloorElevator int64 `json:"perfloorelevator" validate:"min=0"`
+	MinimumPrice     int64 `json:"minimumprice" validate:"min=0"`
+	// Surcharges in percent of the subtotal. Only the larger applies when
+	// a holiday falls on a weekend.
+	WeekendPercent int64 `json:"weekendpercent" validate:"min=0,max=200"`
+	HolidayPercent int64 `json:"holidaypercent" validate:"min=0,max=200"`
+	// WeekendDays are lowercase English day names
+	WeekendDays []string `json:"weekenddays"`
+	// Holidays are YYYY-MM-DD dates, or MM-DD for ones on the same date
+	// every year
+	Holidays []string `json:"holidays"`
+}
+
+// DefaultRateCard is the card used until an admin saves one; migration
+// 0009 seeds the same values
+var DefaultRateCard = RateCard{
+	Currency: DefaultCurrency,
+	Services: map[string]int64{
+		"moving":    800000,
+		"transport": 500000,
+		"packing":   300000,
+	},
+	Itemtypes: map[string]int64{
+		"furniture":  0,
+		"appliances": 150000,
+		"office":     300000,
+		"piano":      500000,
+	},
+	PerWorker:        200000,
+	PerKm:            5000,
+	MinDistanceKm:    5,
+	PerFloorStairs:   100000,
+	PerFloorElevator: 20000,
+	MinimumPrice:     1000000,
+	WeekendPercent:   15,
+	HolidayPercent:   25,
+	WeekendDays:      []string{"friday", "saturday"},
+	Holidays:         []string{"01-01", "01-12", "05-01", "07-05", "11-01"},
+}
+
+// clone returns a deep copy of c, so callers can't modify a store's card
+func (c *RateCard) clone() *RateCard {
+	card := *c
+	card.Services = make(map[string]int64, len(c.Services))
+	for k, v := range c.Services {
+		card.Services[k] = v
+	}
+	card.Itemtypes = make(map[string]int64, len(c.Itemtypes))
+	for k, v := range c.Itemtypes {
+		card.Itemtypes[k] = v
+	}
+	card.WeekendDays = append([]string(nil), c.WeekendDays...)
+	card.Holidays = append([]string(nil), c.Holidays...)
+	return &card
+}
+
+var holidayRegex = regexp.MustCompile(`^(\d{4}-)?\d{2}-\d{2}$`)
+
+// validate checks the card's validate tags and what they can't express
+func (c *RateCard) validate() error {
+	verr := new(ValidationError)
+	if err := Validate(c); err != nil {
+		verr = err.(*ValidationError)
+	}
+
+	for _, prices := range []struct {
+		field  string
+		prices map[string]int64
+	}{{"services", c.Services}, {"itemtypes", c.Itemtypes}} {
+		for name, price := range prices.prices {
+			if strings.TrimSpace(name) == "" || price < 0 {
+				verr.add(prices.field+"."+name, CodeInvalidValue, "must be a name with a price of at least 0")
+			}
+		}
+	}
+	for i, day := range c.WeekendDays {
+		if _, ok := weekdays[day]; !ok {
+			verr.add(fmt.Sprintf("weekenddays[%d]", i), CodeInvalidValue, "must be a lowercase day name")
+		}
+	}
+	for i, day := range c.Holidays {
+		date := day
+		if len(day) == len("01-02") {
+			date = "2000-" + day // a leap year, so 02-29 is accepted
+		}
+		if _, err := time.Parse(time.DateOnly, date); err != nil || !holidayRegex.MatchString(day) {
+			verr.add(fmt.Sprintf("holidays[%d]", i), CodeInvalidValue, "must be a YYYY-MM-DD or MM-DD date")
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// QuoteRequest is what a price depends on, asked by the website's
+// calculator before an order and part of every order
+type QuoteRequest struct {
+	Itemtype    string     `json:"itemtype" validate:"required,max=100"`
+	Service     string     `json:"service" validate:"required,max=100"`
+	Flor        int        `json:"flor" validate:"min=-5,max=200"`
+	Elevator    bool       `json:"elevator"`
+	Workers     int        `json:"workers" validate:"min=1,max=50"`
+	Start       string     `json:"start" validate:"required,max=100"`
+	Distination string     `json:"distination" validate:"required,max=100"`
+	MoveDate    *time.Time `json:"movedate" validate:"required,future"`
+}
+
+// Quote is a computed price with the lines it adds up, kept on the command
+// so staff can see how the price was derived
+type Quote struct {
+	RateCardID int64       `json:"ratecardid"`
+	DistanceKm int64       `json:"distancekm"`
+	Lines      []QuoteLine `json:"lines"`
+	Total      Money       `json:"total"`
+	QuotedAt   time.Time   `json:"quotedat"`
+	// ManualPricing is set when an address names no city we know: the
+	// minimum distance is charged and staff price the move before quoting
+	// it to the customer
+	ManualPricing bool `json:"manualpricing"`
+}
+
+// QuoteLine is one component of a quote: Quantity times UnitPrice, or a
+// Percent of the lines before it
+type QuoteLine struct {
+	Code      string `json:"code"`
+	Label     string `json:"label"`
+	Quantity  int64  `json:"quantity,omitempty"`
+	UnitPrice int64  `json:"unitprice,omitempty"`
+	Percent   int64  `json:"percent,omitempty"`
+	Amount    int64  `json:"amount"`
+}
+
+// Quote line codes
+const (
+	LineService  = "service"
+	LineItemtype = "itemtype"
+	LineWorkers  = "workers"
+	LineDistance = "distance"
+	LineFloors   = "floors"
+	LineMinimum  = "minimum"
+	LineWeekend  = "weekend"
+	LineHoliday  = "holiday"
+)
+
+// roadFactor converts straight-line distances to rough road distances
+const roadFactor = 1.3
+
+// algiersTime is the time zone move dates are priced in. Algeria keeps
+// UTC+1 all year.
+var algiersTime = time.FixedZone("Africa/Algiers", 60*60)
+
+// computeQuote prices req with card. Services and item types the card
+// doesn't know are reported as a *ValidationError; places the locator
+// doesn't know are priced for manual review, see Quote.ManualPricing.
+func computeQuote(card *RateCard, locator Locator, req *QuoteRequest) (*Quote, error) {
+	verr := new(ValidationError)
+	servicePrice, ok := card.Services[req.Service]
+	if !ok {
+		verr.add("service", CodeInvalidValue, "must be one of "+strings.Join(sortedKeys(card.Services), ", "))
+	}
+	itemtypePrice, ok := card.Itemtypes[req.Itemtype]
+	if !ok {
+		verr.add("itemtype", CodeInvalidValue, "must be one of "+strings.Join(sortedKeys(card.Itemtypes), ", "))
+	}
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+
+	var km int64
+	from, fromOK := locator.Locate(req.Start)
+	to, toOK := locator.Locate(req.Distination)
+	if fromOK && toOK {
+		km = int64(math.Round(from.distanceKm(to) * roadFactor))
+	}
+	if km < card.MinDistanceKm {
+		km = card.MinDistanceKm
+	}
+	floors := int64(req.Flor)
+	if floors < 0 {
+		floors = -floors
+	}
+	perFloor, floorLabel := card.PerFloorStairs, "Floors, by the stairs"
+	if req.Elevator {
+		perFloor, floorLabel = card.PerFloorElevator, "Floors, with an elevator"
+	}
+
+	q := &Quote{RateCardID: card.ID, DistanceKm: km, QuotedAt: time.Now().UTC(), ManualPricing: !fromOK || !toOK}
+	q.add(QuoteLine{Code: LineService, Label: "Service: " + req.Service, Quantity: 1, UnitPrice: servicePrice})
+	q.add(QuoteLine{Code: LineItemtype, Label: "Items: " + req.Itemtype, Quantity: 1, UnitPrice: itemtypePrice})
+	q.add(QuoteLine{Code: LineWorkers, Label: "Workers", Quantity: int64(req.Workers), UnitPrice: card.PerWorker})
+	q.add(QuoteLine{Code: LineDistance, Label: "Distance (km)", Quantity: km, UnitPrice: card.PerKm})
+	if floors > 0 {
+		q.add(QuoteLine{Code: LineFloors, Label: floorLabel, Quantity: floors, UnitPrice: perFloor})
+	}
+	if subtotal := q.sum(); subtotal < card.MinimumPrice {
+		q.Lines = append(q.Lines, QuoteLine{Code: LineMinimum, Label: "Minimum price", Amount: card.MinimumPrice - subtotal})
+	}
+
+	// Surcharges apply to the subtotal, the larger one winning
+	day := req.MoveDate.In(algiersTime)
+	surcharge := QuoteLine{}
+	if card.isWeekend(day) {
+		surcharge = QuoteLine{Code: LineWeekend, Label: "Weekend surcharge", Percent: card.WeekendPercent}
+	}
+	if card.isHoliday(day) && card.HolidayPercent > surcharge.Percent {
+		surcharge = QuoteLine{Code: LineHoliday, Label: "Holiday surcharge", Percent: card.HolidayPercent}
+	}
+	if surcharge.Percent > 0 {
+		surcharge.Amount = q.sum() * surcharge.Percent / 100
+		q.Lines = append(q.Lines, surcharge)
+	}
+
+	q.Total = Money{Amount: q.sum(), Currency: card.Currency}
+	return q, nil
+}
+
+func (q *Quote) add(line QuoteLine) {
+	line.Amount = line.Quantity * line.UnitPrice
+	q.Lines = append(q.Lines, line)
+}
+
+func (q *Quote) sum() int64 {
+	var total int64
+	for _, line := range q.Lines {
+		total += line.Amount
+	}
+	return total
+}
+
+func (c *RateCard) isWeekend(day time.Time) bool {
+	for _, name := range c.WeekendDays {
+		if weekdays[name] == day.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *RateCard) isHoliday(day time.Time) bool {
+	for _, holiday := range c.Holidays {
+		if holiday == day.Format(time.DateOnly) || holiday == day.Format("01-02") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *APIServer) handleCreateQuote(w http.ResponseWriter, r *http.Request) error {
+	req := new(QuoteRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	card, err := s.store.GetRateCard(r.Context())
+	if err != nil {
+		return err
+	}
+	quote, err := computeQuote(card, s.locator, req)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, quote)
+}
+
+func (s *APIServer) handleGetRateCard(w http.ResponseWriter, r *http.Request) error {
+	card, err := s.store.GetRateCard(r.Context())
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, card)
+}
+
+func (s *APIServer) handlePutRateCard(w http.ResponseWriter, r *http.Request) error {
+	card := new(RateCard)
+	if err := decodeJSON(r, card); err != nil {
+		return err
+	}
+	if err := card.validate(); err != nil {
+		return err
+	}
+
+	card.CreatedBy = currentUserID(r)
+	if err := s.store.SaveRateCard(r.Context(), card); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, card)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestComputeQuote(t *testing.T) {
+	day := func(year int, month time.Month, d int) *time.Time {
+		t := time.Date(year, month, d, 10, 0, 0, 0, algiersTime)
+		return &t
+	}
+	newCard := func() *RateCard {
+		return &RateCard{
+			ID:               7,
+			Currency:         "DZD",
+			Services:         map[string]int64{"moving": 1000},
+			Itemtypes:        map[string]int64{"furniture": 0, "piano": 500},
+			PerWorker:        100,
+			PerKm:            10,
+			MinDistanceKm:    5,
+			PerFloorStairs:   50,
+			PerFloorElevator: 10,
+			WeekendPercent:   10,
+			HolidayPercent:   20,
+			WeekendDays:      []string{"friday", "saturday"},
+			Holidays:         []string{"01-01", "2026-11-01"},
+		}
+	}
+	// A Monday move of 2 workers within Algiers: 1000 + 2×100 + 5 km×10
+	const base = 1250
+
+	tests := []struct {
+		name   string
+		change func(req *QuoteRequest, card *RateCard)
+		lines  []string
+		total  int64
+		manual bool
+	}{
+		{
+			name:  "within a city",
+			lines: []string{LineService, LineItemtype, LineWorkers, LineDistance},
+			total: base,
+		},
+		{
+			name:   "floors by the stairs",
+			change: func(req *QuoteRequest, card *RateCard) { req.Flor = 3 },
+			lines:  []string{LineService, LineItemtype, LineWorkers, LineDistance, LineFloors},
+			total:  base + 3*50,
+		},
+		{
+			name:   "floors with an elevator",
+			change: func(req *QuoteRequest, card *RateCard) { req.Flor, req.Elevator = 3, true },
+			lines:  []string{LineService, LineItemtype, LineWorkers, LineDistance, LineFloors},
+			total:  base + 3*10,
+		},
+		{
+			name:   "basement floors",
+			change: func(req *QuoteRequest, card *RateCard) { req.Flor = -2 },
+			lines:  []string{LineService, LineItemtype, LineWorkers, LineDistance, LineFloors},
+			total:  base + 2*50,
+		},
+		{
+			name:   "item type",
+			change: func(req *QuoteRequest, card *RateCard) { req.Itemtype = "piano" },
+			lines:  []string{LineService, LineItemtype, LineWorkers, LineDistance},
+			total:  base + 500,
+		},
+		{
+			name:   "minimum price",
+			change: func(req *QuoteRequest, card *RateCard) { card.MinimumPrice = 2000 },
+			lines:  []string{LineService, LineItemtype, LineWorkers, LineDistance, LineMinimum},
+			total:  2000,
+		},
+		{
+			name: "surcharge on the minimum price",
+			change: func(req *QuoteRequest, card *RateCard) {
+				card.MinimumPrice = 2000
+				req.MoveDate = day(2026, time.October, 23) // a Friday
+			},
+			lines: []string{LineService, LineItemtype, LineWorkers, LineDistance, LineMinimum, LineWeekend},
+			total: 2200,
+		},
+		{
+			name:   "weekend",
+			change: func(req *QuoteRequest, card *RateCard) { req.MoveDate = day(2026, time.October, 23) },
+			lines:  []string{LineService, LineItemtype, LineWorkers, LineDistance, LineWeekend},
+			total:  base + base*10/100,
+		},
+		{
+			name:   "holiday on a weekday",
+			change: func(req *QuoteRequest, card *RateCard) { req.MoveDate = day(2026, time.November, 1) },
+			lines:  []string{LineService, LineItemtype, LineWorkers, LineDistance, LineHoliday},
+			total:  base + base*20/100,
+		},
+		{
+			name:   "holiday on a weekend, holiday larger",
+			change: func(req *QuoteRequest, card *RateCard) { req.MoveDate = day(2027, time.January, 1) },
+			lines:  []string{LineService, LineItemtype, LineWorkers, LineDistance, LineHoliday},
+			total:  base + base*20/100,
+		},
+		{
+			name: "holiday on a weekend, weekend larger",
+			change: func(req *QuoteRequest, card *RateCard) {
+				card.WeekendPercent = 30
+				req.MoveDate = day(2027, time.January, 1)
+			},
+			lines: []string{LineService, LineItemtype, LineWorkers, LineDistance, LineWeekend},
+			total: base + base*30/100,
+		},
+		{
+			name: "holiday in the evening in Algiers",
+			change: func(req *QuoteRequest, card *RateCard) {
+				// Still October 31 in UTC
+				d := time.Date(2026, time.October, 31, 23, 30, 0, 0, time.UTC)
+				req.MoveDate = &d
+			},
+			lines: []string{LineService, LineItemtype, LineWorkers, LineDistance, LineHoliday},
+			total: base + base*20/100,
+		},
+		{
+			name:   "unknown start",
+			change: func(req *QuoteRequest, card *RateCard) { req.Start = "12 rue des Oliviers" },
+			lines:  []string{LineService, LineItemtype, LineWorkers, LineDistance},
+			total:  base,
+			manual: true,
+		},
+		{
+			name:   "unknown destination",
+			change: func(req *QuoteRequest, card *RateCard) { req.Distination = "chez Karim" },
+			lines:  []string{LineService, LineItemtype, LineWorkers, LineDistance},
+			total:  base,
+			manual: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			card := newCard()
+			req := &QuoteRequest{
+				Itemtype:    "furniture",
+				Service:     "moving",
+				Workers:     2,
+				Start:       "Bab Ezzouar, Alger",
+				Distination: "Hydra, Alger",
+				MoveDate:    day(2026, time.October, 19),
+			}
+			if tt.change != nil {
+				tt.change(req, card)
+			}
+
+			q, err := computeQuote(card, algerianCities, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lines []string
+			for _, line := range q.Lines {
+				lines = append(lines, line.Code)
+			}
+			if !reflect.DeepEqual(lines, tt.lines) {
+				t.Errorf("lines = %v, want %v", lines, tt.lines)
+			}
+			if q.Total != (Money{Amount: tt.total, Currency: "DZD"}) {
+				t.Errorf("total = %v, want %d DZD", q.Total, tt.total)
+			}
+			if q.ManualPricing != tt.manual {
+				t.Errorf("manual pricing = %v, want %v", q.ManualPricing, tt.manual)
+			}
+			if q.RateCardID != card.ID || q.DistanceKm != card.MinDistanceKm {
+				t.Errorf("rate card %d, %d km, want %d, %d km", q.RateCardID, q.DistanceKm, card.ID, card.MinDistanceKm)
+			}
+		})
+	}
+}
+
+func TestComputeQuoteDistance(t *testing.T) {
+	moveDate := time.Date(2026, time.October, 19, 10, 0, 0, 0, algiersTime)
+	req := &QuoteRequest{Itemtype: "furniture", Service: "moving", Workers: 1, Start: "Alger", Distination: "Oran", MoveDate: &moveDate}
+
+	q, err := computeQuote(&DefaultRateCard, algerianCities, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// About 350 km as the crow flies
+	if q.DistanceKm < 440 || q.DistanceKm > 480 || q.ManualPricing {
+		t.Errorf("Alger to Oran = %d km, manual pricing %v, want about 460 km by road", q.DistanceKm, q.ManualPricing)
+	}
+}
+
+func TestComputeQuoteUnknownPrices(t *testing.T) {
+	moveDate := time.Date(2026, time.October, 19, 10, 0, 0, 0, algiersTime)
+	req := &QuoteRequest{Itemtype: "boat", Service: "storage", Workers: 1, Start: "Alger", Distination: "Oran", MoveDate: &moveDate}
+
+	_, err := computeQuote(&DefaultRateCard, algerianCities, req)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Fields) != 2 {
+		t.Fatalf("computeQuote() error = %v, want the service and item type invalid", err)
+	}
+}
+
+func TestCreateCommandAtUnknownPlaces(t *testing.T) {
+	a := newWorkerAPI(t)
+	moveDate := time.Now().Add(72 * time.Hour)
+	req := &CreateCommandRequest{
+		FullName: "Karim Test",
+		Number:   "0550123456",
+		QuoteRequest: QuoteRequest{
+			Itemtype:    "furniture",
+			Service:     "moving",
+			Workers:     2,
+			Start:       "12 rue des Oliviers",
+			Distination: "chez Karim, route de la plage",
+			MoveDate:    &moveDate,
+		},
+	}
+
+	for _, path := range []string{apiV1 + "/commands", "/CreateCommand"} {
+		resp, body := a.do(http.MethodPost, path, nil, req)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("POST %s = %d %s, want 201", path, resp.StatusCode, body)
+		}
+		command := new(Command)
+		if err := json.Unmarshal([]byte(body), command); err != nil {
+			t.Fatal(err)
+		}
+		if command.Quote == nil || !command.Quote.ManualPricing {
+			t.Errorf("POST %s quote = %+v, want it marked for manual pricing", path, command.Quote)
+		}
+	}
+}
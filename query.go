@@ -0,0 +1,558 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+var ErrInvalidCursor = BadRequest("invalid_cursor", "invalid cursor")
+
+// Page is one slice of a listing plus what's needed to fetch the next one
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextcursor,omitempty"`
+	Total      int    `json:"total"`
+}
+
+// CommandQuery filters, sorts and paginates GetCommands. Zero values mean
+// "no filter".
+type CommandQuery struct {
+	Status   []CommandStatus
+	Service  string
+	Itemtype string
+	From     *time.Time // move date, inclusive
+	To       *time.Time // move date, exclusive
+	MinPrice *int64     // minor units, inclusive
+	MaxPrice *int64     // minor units, inclusive
+	Sort     string     // created_at, price or movedate, prefixed with - for descending
+	Cursor   string
+	Limit    int
+}
+
+// WorkerQuery filters, sorts and paginates GetWorkers
+type WorkerQuery struct {
+	Position string
+	Accepted *bool
+	Role     Role
+	Status   []ApplicationStatus
+	Sort     string // created_at or fullname, prefixed with - for descending
+	Cursor   string
+	Limit    int
+}
+
+// TimeOffQuery filters GetTimeOff
+type TimeOffQuery struct {
+	WorkerID string
+	Status   TimeOffStatus
+}
+
+// AvailabilityQuery asks GetAvailableWorkers for the workers free from
+// From to To, of Position when set
+type AvailabilityQuery struct {
+	From     time.Time
+	To       time.Time
+	Position string
+}
+
+// maxAvailabilityWindow bounds the window workers are looked up over
+const maxAvailabilityWindow = 31 * 24 * time.Hour
+
+// sortField is a sort option: the SQL expression to order by, the type its
+// cursor key is cast to, and how to compute the same key in Go. Keys are
+// built so that comparing them as strings matches the SQL ordering.
+type sortField[T any] struct {
+	column string
+	cast   string
+	key    func(T) string
+}
+
+var commandSortFields = map[string]sortField[*Command]{
+	"created_at": {"created_at", "timestamptz", func(c *Command) string { return timeKey(c.CreatedAt) }},
+	"price":      {"price_amount", "bigint", func(c *Command) string { return intKey(c.Prix.Amount) }},
+	"movedate": {"coalesce(move_date, 'epoch'::timestamptz)", "timestamptz", func(c *Command) string {
+		if c.MoveDate == nil {
+			return timeKey(time.Unix(0, 0))
+		}
+		return timeKey(*c.MoveDate)
+	}},
+}
+
+var workerSortFields = map[string]sortField[*Worker]{
+	"created_at": {"created_at", "timestamptz", func(w *Worker) string { return timeKey(w.CreatedAt) }},
+	"fullname":   {`fullname COLLATE "C"`, "text", func(w *Worker) string { return w.FullName }},
+}
+
+func timeKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z07:00")
+}
+
+func intKey(n int64) string {
+	return fmt.Sprintf("%020d", n)
+}
+
+// pageCursor is the position after the last item of a page, (key, id)
+// being the stable ordering of the listing
+type pageCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"id"`
+}
+
+func encodeCursor(c pageCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor, rejecting ones issued for another sort and
+// ones whose key isn't of cast, the type of the sort field, or whose id
+// isn't a uuid: they'd otherwise only fail when cast in SQL
+func decodeCursor(s, sortBy, cast string) (*pageCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := new(pageCursor)
+	if err := json.Unmarshal(b, c); err != nil || c.Sort != sortBy || !isUUID(c.ID) || !validCursorKey(c.Key, cast) {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// validCursorKey reports whether key can be cast to the SQL type cast
+func validCursorKey(key, cast string) bool {
+	switch cast {
+	case "timestamptz":
+		_, err := time.Parse(time.RFC3339Nano, key)
+		return err == nil
+	case "bigint":
+		_, err := strconv.ParseInt(key, 10, 64)
+		return err == nil
+	case "text":
+		return utf8.ValidString(key) && !strings.ContainsRune(key, 0)
+	default:
+		return false
+	}
+}
+
+// isUUID reports whether s is a uuid in its canonical, hyphenated form
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if c != '-' {
+				return false
+			}
+		case !strings.ContainsRune("0123456789abcdefABCDEF", c):
+			return false
+		}
+	}
+	return true
+}
+
+// parseSort splits "-price" into ("price", true), defaulting to created_at
+func parseSort[T any](s string, fields map[string]sortField[T]) (sortField[T], bool, error) {
+	if s == "" {
+		s = "created_at"
+	}
+	desc := strings.HasPrefix(s, "-")
+	field, ok := fields[strings.TrimPrefix(s, "-")]
+	if !ok {
+		return field, false, BadRequest("invalid_query", "invalid sort %q", s)
+	}
+	return field, desc, nil
+}
+
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultPageSize
+	}
+	if limit > maxPageSize {
+		return maxPageSize
+	}
+	return limit
+}
+
+// sqlWhere accumulates AND-ed conditions, numbering "?" placeholders as
+// $1, $2, ... in the order arguments are added
+type sqlWhere struct {
+	clauses []string
+	args    []any
+}
+
+func (w *sqlWhere) add(clause string, args ...any) {
+	for _, arg := range args {
+		w.args = append(w.args, arg)
+		clause = strings.Replace(clause, "?", "$"+strconv.Itoa(len(w.args)), 1)
+	}
+	w.clauses = append(w.clauses, clause)
+}
+
+func (w *sqlWhere) String() string {
+	if len(w.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.clauses, " AND ")
+}
+
+// addCursor restricts the query to rows after cursor in (field, id) order
+func addCursor[T any](w *sqlWhere, field sortField[T], desc bool, cursor *pageCursor) {
+	if cursor == nil {
+		return
+	}
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	w.add(fmt.Sprintf("(%s, id) %s (?::%s, ?::uuid)", field.column, op, field.cast), cursor.Key, cursor.ID)
+}
+
+func orderBy[T any](field sortField[T], desc bool) string {
+	if desc {
+		return fmt.Sprintf(" ORDER BY %s DESC, id DESC", field.column)
+	}
+	return fmt.Sprintf(" ORDER BY %s, id", field.column)
+}
+
+func (q *CommandQuery) where() *sqlWhere {
+	w := new(sqlWhere)
+	if len(q.Status) > 0 {
+		statuses := make([]string, len(q.Status))
+		for i, status := range q.Status {
+			statuses[i] = string(status)
+		}
+		w.add("status = ANY(?::varchar[])", "{"+strings.Join(statuses, ",")+"}")
+	}
+	if q.Service != "" {
+		w.add("services = ?", q.Service)
+	}
+	if q.Itemtype != "" {
+		w.add("itemtype = ?", q.Itemtype)
+	}
+	if q.From != nil {
+		w.add("move_date >= ?", *q.From)
+	}
+	if q.To != nil {
+		w.add("move_date < ?", *q.To)
+	}
+	if q.MinPrice != nil {
+		w.add("price_amount >= ?", *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		w.add("price_amount <= ?", *q.MaxPrice)
+	}
+	return w
+}
+
+// matches is the in-memory equivalent of where
+func (q *CommandQuery) matches(c *Command) bool {
+	if len(q.Status) > 0 {
+		found := false
+		for _, status := range q.Status {
+			found = found || c.Status == status
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.Service != "" && c.Service != q.Service {
+		return false
+	}
+	if q.Itemtype != "" && c.Itemtype != q.Itemtype {
+		return false
+	}
+	if q.From != nil && (c.MoveDate == nil || c.MoveDate.Before(*q.From)) {
+		return false
+	}
+	if q.To != nil && (c.MoveDate == nil || !c.MoveDate.Before(*q.To)) {
+		return false
+	}
+	if q.MinPrice != nil && c.Prix.Amount < *q.MinPrice {
+		return false
+	}
+	if q.MaxPrice != nil && c.Prix.Amount > *q.MaxPrice {
+		return false
+	}
+	return true
+}
+
+func (q *WorkerQuery) where() *sqlWhere {
+	w := new(sqlWhere)
+	if q.Position != "" {
+		w.add("position = ?", q.Position)
+	}
+	if q.Accepted != nil {
+		w.add("isaccepted = ?", *q.Accepted)
+	}
+	if q.Role != "" {
+		w.add("role = ?", q.Role)
+	}
+	if len(q.Status) > 0 {
+		statuses := make([]string, len(q.Status))
+		for i, status := range q.Status {
+			statuses[i] = string(status)
+		}
+		w.add("application_status = ANY(?::varchar[])", "{"+strings.Join(statuses, ",")+"}")
+	}
+	return w
+}
+
+func (q *WorkerQuery) matches(w *Worker) bool {
+	if q.Position != "" && w.Position != q.Position {
+		return false
+	}
+	if q.Accepted != nil && w.IsAccepted != *q.Accepted {
+		return false
+	}
+	if q.Role != "" && w.Role != q.Role {
+		return false
+	}
+	if len(q.Status) > 0 {
+		found := false
+		for _, status := range q.Status {
+			found = found || w.ApplicationStatus == status
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// paginate sorts the already filtered items and cuts the page after
+// cursor, mirroring the keyset queries run against Postgres
+func paginate[T any](items []T, id func(T) string, sortBy string, fields map[string]sortField[T], cursorStr string, limit int) (*Page[T], error) {
+	field, desc, err := parseSort(sortBy, fields)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(cursorStr, sortBy, field.cast)
+	if err != nil {
+		return nil, err
+	}
+	limit = pageLimit(limit)
+
+	compare := func(a, b T) int {
+		if c := strings.Compare(field.key(a), field.key(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(id(a), id(b))
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if desc {
+			return compare(items[i], items[j]) > 0
+		}
+		return compare(items[i], items[j]) < 0
+	})
+
+	page := &Page[T]{Items: []T{}, Total: len(items)}
+	for _, item := range items {
+		if cursor != nil {
+			c := strings.Compare(field.key(item), cursor.Key)
+			if c == 0 {
+				c = strings.Compare(id(item), cursor.ID)
+			}
+			if (!desc && c <= 0) || (desc && c >= 0) {
+				continue
+			}
+		}
+		page.Items = append(page.Items, item)
+		if len(page.Items) == limit+1 {
+			break
+		}
+	}
+	setNextCursor(page, id, sortBy, field, limit)
+
+	return page, nil
+}
+
+// setNextCursor trims the extra item fetched beyond limit and, if there
+// was one, points the next cursor at the last item kept
+func setNextCursor[T any](page *Page[T], id func(T) string, sortBy string, field sortField[T], limit int) {
+	if len(page.Items) <= limit {
+		return
+	}
+	page.Items = page.Items[:limit]
+	last := page.Items[limit-1]
+	page.NextCursor = encodeCursor(pageCursor{Sort: sortBy, Key: field.key(last), ID: id(last)})
+}
+
+// ParseCommandQuery reads CommandQuery from URL query parameters
+func ParseCommandQuery(v url.Values) (*CommandQuery, error) {
+	q := &CommandQuery{
+		Service:  v.Get("service"),
+		Itemtype: v.Get("itemtype"),
+		Sort:     v.Get("sort"),
+		Cursor:   v.Get("cursor"),
+	}
+
+	for _, s := range v["status"] {
+		for _, status := range strings.Split(s, ",") {
+			if !CommandStatus(status).Valid() {
+				return nil, BadRequest("invalid_query", "invalid status %q", status)
+			}
+			q.Status = append(q.Status, CommandStatus(status))
+		}
+	}
+
+	var err error
+	if q.From, err = parseTimeParam(v, "from"); err != nil {
+		return nil, err
+	}
+	if q.To, err = parseTimeParam(v, "to"); err != nil {
+		return nil, err
+	}
+	if q.MinPrice, err = parseIntParam(v, "minprice"); err != nil {
+		return nil, err
+	}
+	if q.MaxPrice, err = parseIntParam(v, "maxprice"); err != nil {
+		return nil, err
+	}
+	if q.Limit, err = parseLimitParam(v); err != nil {
+		return nil, err
+	}
+	if _, _, err := parseSort(q.Sort, commandSortFields); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// ParseWorkerQuery reads WorkerQuery from URL query parameters
+func ParseWorkerQuery(v url.Values) (*WorkerQuery, error) {
+	q := &WorkerQuery{
+		Position: v.Get("position"),
+		Role:     Role(v.Get("role")),
+		Sort:     v.Get("sort"),
+		Cursor:   v.Get("cursor"),
+	}
+
+	if s := v.Get("accepted"); s != "" {
+		accepted, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, BadRequest("invalid_query", "invalid accepted %q", s)
+		}
+		q.Accepted = &accepted
+	}
+
+	if q.Role != "" && !q.Role.Valid() {
+		return nil, BadRequest("invalid_query", "invalid role %q", q.Role)
+	}
+
+	for _, s := range v["status"] {
+		for _, status := range strings.Split(s, ",") {
+			if !ApplicationStatus(status).Valid() {
+				return nil, BadRequest("invalid_query", "invalid status %q", status)
+			}
+			q.Status = append(q.Status, ApplicationStatus(status))
+		}
+	}
+
+	var err error
+	if q.Limit, err = parseLimitParam(v); err != nil {
+		return nil, err
+	}
+	if _, _, err := parseSort(q.Sort, workerSortFields); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// ParseTimeOffQuery reads TimeOffQuery from URL query parameters
+func ParseTimeOffQuery(v url.Values) (*TimeOffQuery, error) {
+	q := &TimeOffQuery{
+		WorkerID: v.Get("worker"),
+		Status:   TimeOffStatus(v.Get("status")),
+	}
+
+	if q.Status != "" && !q.Status.Valid() {
+		return nil, BadRequest("invalid_query", "invalid status %q", q.Status)
+	}
+
+	return q, nil
+}
+
+// ParseAvailabilityQuery reads AvailabilityQuery from URL query
+// parameters, from and to being required
+func ParseAvailabilityQuery(v url.Values) (*AvailabilityQuery, error) {
+	q := &AvailabilityQuery{Position: v.Get("position")}
+
+	for _, param := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		t, err := parseTimeParam(v, param.name)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			return nil, BadRequest("invalid_query", "missing %s", param.name)
+		}
+		*param.dst = *t
+	}
+
+	if !q.To.After(q.From) {
+		return nil, BadRequest("invalid_query", "to must be after from")
+	}
+	if q.To.Sub(q.From) > maxAvailabilityWindow {
+		return nil, BadRequest("invalid_query", "window must be at most %d days", maxAvailabilityWindow/(24*time.Hour))
+	}
+
+	return q, nil
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or plain YYYY-MM-DD dates
+func parseTimeParam(v url.Values, name string) (*time.Time, error) {
+	s := v.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, BadRequest("invalid_query", "invalid %s %q: expected RFC 3339 or YYYY-MM-DD", name, s)
+}
+
+func parseIntParam(v url.Values, name string) (*int64, error) {
+	s := v.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, BadRequest("invalid_query", "invalid %s %q", name, s)
+	}
+	return &n, nil
+}
+
+func parseLimitParam(v url.Values) (int, error) {
+	s := v.Get("limit")
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, BadRequest("invalid_query", "invalid limit %q", s)
+	}
+	return n, nil
+}
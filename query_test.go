@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	const id = "7f1c1a52-3f0e-4c55-9d1e-2b8f4a6c9e01"
+	raw := func(json string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(json))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		sort   string
+		cast   string
+		ok     bool
+	}{
+		{"issued", encodeCursor(pageCursor{Sort: "price", Key: intKey(1500), ID: id}), "price", "bigint", true},
+		{"time key", encodeCursor(pageCursor{Sort: "", Key: "2026-10-18T09:00:00.000000000Z", ID: id}), "", "timestamptz", true},
+		{"text key", encodeCursor(pageCursor{Sort: "fullname", Key: "Amine", ID: id}), "fullname", "text", true},
+		{"not base64", "%%%", "price", "bigint", false},
+		{"not json", raw("nope"), "price", "bigint", false},
+		{"other sort", encodeCursor(pageCursor{Sort: "movedate", Key: intKey(1), ID: id}), "price", "bigint", false},
+		{"number key not a number", raw(`{"s":"price","k":"1; drop","id":"` + id + `"}`), "price", "bigint", false},
+		{"time key not a time", raw(`{"s":"","k":"yesterday","id":"` + id + `"}`), "", "timestamptz", false},
+		{"text key with nul", raw(`{"s":"fullname","k":"a\u0000b","id":"` + id + `"}`), "fullname", "text", false},
+		{"id not a uuid", raw(`{"s":"price","k":"1","id":"42"}`), "price", "bigint", false},
+		{"missing id", raw(`{"s":"price","k":"1"}`), "price", "bigint", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := decodeCursor(tt.cursor, tt.sort, tt.cast)
+			if tt.ok {
+				if err != nil || c == nil {
+					t.Fatalf("decodeCursor() = %v, %v, want a cursor", c, err)
+				}
+				return
+			}
+			if err != ErrInvalidCursor {
+				t.Fatalf("decodeCursor() error = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
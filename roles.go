@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role decides which routes an account may call
+type Role string
+
+const (
+	RoleAdmin      Role = "admin"
+	RoleDispatcher Role = "dispatcher"
+	RoleWorker     Role = "worker"
+)
+
+var (
+	ErrAdminExists           = Conflict("admin_exists", "an admin account already exists")
+	ErrAdminPasswordRequired = Invalid(CodeRequired, "a password is required for the admin account")
+)
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	switch r {
+	case RoleAdmin, RoleDispatcher, RoleWorker:
+		return true
+	}
+	return false
+}
+
+// withRoles only lets through accounts having one of roles. It must be
+// wrapped by withJWTAuth, which puts the caller's role in the context.
+// API keys carry no role and are always refused.
+func withRoles(handlerFunc http.HandlerFunc, roles ...Role) http.HandlerFunc {
+	return withAccess(handlerFunc, "", roles...)
+}
+
+// withAccess is withRoles that also lets through API keys granted scope.
+// Accounts the two-factor policy applies to are refused until they enable
+// it.
+func withAccess(handlerFunc http.HandlerFunc, scope Scope, roles ...Role) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key := currentAPIKey(r); key != nil {
+			if scope != "" && key.HasScope(scope) {
+				handlerFunc(w, r)
+				return
+			}
+			permissionDenied(w, r)
+			return
+		}
+
+		if twoFactorPending(r) {
+			writeError(w, r, ErrTwoFactorRequired)
+			return
+		}
+
+		role := currentRole(r)
+		for _, allowed := range roles {
+			if role == allowed {
+				handlerFunc(w, r)
+				return
+			}
+		}
+		permissionDenied(w, r)
+	}
+}
+
+// currentRole returns the role withJWTAuth put in the request context
+func currentRole(r *http.Request) Role {
+	role, _ := r.Context().Value("role").(Role)
+	return role
+}
+
+// bootstrapAdmin creates the first admin account, refusing once one exists.
+// The password is checked first: an admin that can't log in would still
+// keep any other from being bootstrapped.
+func bootstrapAdmin(ctx context.Context, store Storage, email, password string) error {
+	password = strings.TrimSpace(password)
+	if password == "" {
+		return ErrAdminPasswordRequired
+	}
+	if err := validatePassword(password, DefaultConfig); err != nil {
+		return fmt.Errorf("admin password: %w", err)
+	}
+
+	admins, err := store.GetWorkers(ctx, &WorkerQuery{Role: RoleAdmin, Limit: 1})
+	if err != nil {
+		return err
+	}
+	if admins.Total > 0 {
+		return ErrAdminExists
+	}
+
+	err = store.CreateWorker(ctx, &Worker{
+		FullName:   "Administrator",
+		Email:      email,
+		Password:   password,
+		Position:   string(RoleAdmin),
+		IsAccepted: true,
+		Role:       RoleAdmin,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create admin: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	accessTokenCookie  = "x-jwt-token"
+	refreshTokenCookie = "x-refresh-token"
+)
+
+var (
+	ErrInvalidRefreshToken = Unauthorized("invalid_refresh_token", "invalid or expired refresh token")
+	ErrRefreshTokenReused  = Unauthorized("refresh_token_reused", "refresh token reused, session revoked")
+)
+
+// RefreshToken is one link of a session's rotation chain. Every login
+// starts a family; each refresh marks the presented token used and issues
+// the next one in the same family. Only the token's hash is stored.
+type RefreshToken struct {
+	ID        string
+	FamilyID  string
+	WorkerID  string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshtoken"`
+}
+
+// newRefreshToken returns a random token for the worker and its stored
+// form, without a family: the caller starts one or RotateRefreshToken
+// carries the family over from the token being replaced
+func newRefreshToken(workerID string) (string, *RefreshToken, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &RefreshToken{
+		WorkerID:  workerID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}, nil
+}
+
+// randomToken returns 256 random bits, encoded for URLs and cookies
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a high-entropy random token for storage. Unlike
+// passwords these can't be brute-forced, so a fast hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSession opens a new session for worker and sets its cookies
+func (s *APIServer) startSession(ctx context.Context, w http.ResponseWriter, worker *Worker) (*LoginResponse, error) {
+	if worker == nil {
+		return nil, fmt.Errorf("worker is nil")
+	}
+
+	refresh, stored, err := newRefreshToken(worker.ID)
+	if err != nil {
+		return nil, err
+	}
+	if stored.FamilyID, err = newUUID(); err != nil {
+		return nil, err
+	}
+	if err := s.store.CreateRefreshToken(ctx, stored); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(w, worker, refresh, stored)
+}
+
+// issueTokens signs an access token for the session of stored and sets
+// both tokens as cookies
+func (s *APIServer) issueTokens(w http.ResponseWriter, worker *Worker, refresh string, stored *RefreshToken) (*LoginResponse, error) {
+	token, err := createJWT(worker, stored.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookie,
+		Value:    token,
+		Expires:  time.Now().Add(accessTokenTTL),
+		HttpOnly: true, // Prevent JavaScript access
+		Secure:   true, // Only send over HTTPS
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    refresh,
+		Expires:  stored.ExpiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/auth",
+	})
+
+	return &LoginResponse{ID: worker.ID, Token: token, RefreshToken: refresh}, nil
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, cookie := range []*http.Cookie{
+		{Name: accessTokenCookie, Path: "/"},
+		{Name: refreshTokenCookie, Path: "/auth"},
+	} {
+		cookie.MaxAge = -1
+		cookie.HttpOnly = true
+		cookie.Secure = true
+		cookie.SameSite = http.SameSiteStrictMode
+		http.SetCookie(w, cookie)
+	}
+}
+
+// refreshTokenFromRequest reads the refresh token from its cookie or,
+// for clients without cookies, from the json body
+func refreshTokenFromRequest(r *http.Request) string {
+	if cookie, err := r.Cookie(refreshTokenCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	req := new(RefreshRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return ""
+	}
+	return req.RefreshToken
+}
+
+func (s *APIServer) handleRefresh(w http.ResponseWriter, r *http.Request) error {
+	refresh := refreshTokenFromRequest(r)
+	if refresh == "" {
+		return ErrInvalidRefreshToken
+	}
+
+	next, stored, err := newRefreshToken("")
+	if err != nil {
+		return err
+	}
+	err = s.store.RotateRefreshToken(r.Context(), hashToken(refresh), stored)
+	if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+		clearSessionCookies(w)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	worker, err := s.store.GetAccountByID(r.Context(), stored.WorkerID)
+	if err != nil {
+		return err
+	}
+	if !worker.IsAccepted {
+		// Deactivated since logging in
+		if err := s.store.RevokeSession(r.Context(), stored.FamilyID); err != nil {
+			return err
+		}
+		clearSessionCookies(w)
+		return ErrAccountInactive
+	}
+
+	resp, err := s.issueTokens(w, worker, next, stored)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, resp)
+}
+
+func (s *APIServer) handleLogout(w http.ResponseWriter, r *http.Request) error {
+	if refresh := refreshTokenFromRequest(r); refresh != "" {
+		token, err := s.store.GetRefreshToken(r.Context(), hashToken(refresh))
+		if err == nil {
+			if err := s.store.RevokeSession(r.Context(), token.FamilyID); err != nil {
+				return err
+			}
+		}
+	}
+
+	clearSessionCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleRevokeWorkerSessions logs a worker out everywhere
+func (s *APIServer) handleRevokeWorkerSessions(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.store.GetAccountByID(r.Context(), id); err != nil {
+		return err
+	}
+	if err := s.store.RevokeWorkerSessions(r.Context(), id); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
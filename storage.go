@@ -1,136 +1,168 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
 )
 
 type Storage interface {
-	CreateCommand(*Command) error
-	DeleteCommand(*Command) error
-	GetCommands() ([]*Command, error)
-	CreateWorker(*Worker) error
-	GetWorkers() ([]*Worker, error)
-	Register(string, string) (*Worker, error)
-	GetWorkerByEmail(string) (*Worker, error)
-	GetAccountByID(string) (*Worker, error)
-	createAdminTable() error
-	UpdateCommand(*Command) error
-	UpdateWorker(*Worker) error
-	DropTable(string) error
-	DropAllTables() error
+	CreateCommand(context.Context, *Command) error
+	DeleteCommand(context.Context, *Command) error
+	GetCommands(context.Context, *CommandQuery) (*Page[*Command], error)
+	GetCommandByID(context.Context, string) (*Command, error)
+	TransitionCommand(ctx context.Context, id string, to CommandStatus, changedBy, note string) (*Command, error)
+	PatchCommand(ctx context.Context, id string, patch *CommandPatch, changedBy string) (*Command, error)
+	GetCommandHistory(context.Context, string) ([]*CommandStatusChange, error)
+	CreateWorker(context.Context, *Worker) error
+	GetWorkers(context.Context, *WorkerQuery) (*Page[*Worker], error)
+	Register(context.Context, string, string) (*Worker, error)
+	GetWorkerByEmail(context.Context, string) (*Worker, error)
+	GetAccountByID(context.Context, string) (*Worker, error)
+	UpdateCommand(context.Context, *Command) error
+	UpdateWorker(context.Context, *Worker) error
+	PatchWorker(ctx context.Context, id string, patch *WorkerPatch) (*Worker, error)
+	DeleteWorker(context.Context, string) error
+	GetRateCard(context.Context) (*RateCard, error)
+	SaveRateCard(context.Context, *RateCard) error
+	AssignWorker(context.Context, *Assignment) error
+	UnassignWorker(ctx context.Context, commandID, workerID string) error
+	GetAssignments(ctx context.Context, commandID string) ([]*Assignment, error)
+	GetWorkerJobs(ctx context.Context, workerID string) ([]*Job, error)
+	GetAvailability(ctx context.Context, workerID string) ([]AvailabilitySlot, error)
+	SetAvailability(ctx context.Context, workerID string, slots []AvailabilitySlot) error
+	CreateTimeOff(context.Context, *TimeOff) error
+	GetTimeOff(context.Context, *TimeOffQuery) ([]*TimeOff, error)
+	ReviewTimeOff(ctx context.Context, id string, status TimeOffStatus, reviewedBy string) (*TimeOff, error)
+	GetAvailableWorkers(context.Context, *AvailabilityQuery) ([]*Worker, error)
+	TransitionApplication(ctx context.Context, id string, to ApplicationStatus, changedBy, note string) (*Worker, error)
+	GetApplicationHistory(ctx context.Context, workerID string) ([]*ApplicationStatusChange, error)
+	AddApplicationNote(context.Context, *ApplicationNote) error
+	GetApplicationNotes(ctx context.Context, workerID string) ([]*ApplicationNote, error)
+	CreateInvitation(context.Context, *Invitation) error
+	ActivateAccount(ctx context.Context, invitationID, workerID, password string) error
+	CreatePasswordReset(context.Context, *PasswordReset) error
+	ResetPassword(ctx context.Context, tokenHash, password string) (workerID string, err error)
+	GetLoginThrottle(ctx context.Context, scope ThrottleScope, key string) (*LoginThrottle, error)
+	GetLoginThrottles(ctx context.Context) ([]*LoginThrottle, error)
+	ReserveLoginAttempt(ctx context.Context, scope ThrottleScope, key string) (*LoginThrottle, error)
+	ReleaseLoginAttempt(ctx context.Context, scope ThrottleScope, key string) error
+	ClearLoginThrottle(ctx context.Context, scope ThrottleScope, key string) error
+	GetTOTP(ctx context.Context, workerID string) (*TOTP, error)
+	SaveTOTP(context.Context, *TOTP) error
+	ConfirmTOTP(ctx context.Context, workerID string, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(ctx context.Context, workerID string, step int64) error
+	DeleteTOTP(ctx context.Context, workerID string) error
+	ReplaceRecoveryCodes(ctx context.Context, workerID string, hashes []string) error
+	UseRecoveryCode(ctx context.Context, workerID, hash string) error
+	CreateRefreshToken(context.Context, *RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, hash string, next *RefreshToken) error
+	RevokeSession(ctx context.Context, familyID string) error
+	RevokeWorkerSessions(ctx context.Context, workerID string) error
+	IsSessionActive(ctx context.Context, familyID string) (bool, error)
+	CreateAPIKey(context.Context, *APIKey) error
+	GetAPIKeyByPrefix(context.Context, string) (*APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]*APIKey, error)
+	RevokeAPIKey(context.Context, string) error
+	TouchAPIKey(context.Context, string) error
+	Close() error
 }
 
 type PostgresStore struct {
 	db *sql.DB
 }
 
-func NewPostgresStore() (*PostgresStore, error) {
-	// connStr := "host=127.0.0.1 port=5432 user=postgres dbname=postgres password=gokrixo sslmode=disable"
-
-	// db, err := sql.Open("postgres", connStr)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	// dsn := fmt.Sprintf(
-	// 	"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-	// 	os.Getenv("DB_HOST"),
-	// 	os.Getenv("DB_PORT"),
-	// 	os.Getenv("DB_USER"),
-	// 	os.Getenv("DB_PASSWORD"),
-	// 	os.Getenv("DB_NAME"),
-	// )
-
-	// DO THIS BEFORE PUSH
-	dsn := os.Getenv("DB_HOST")
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = db.Ping()
+// NewPostgresStore opens a pool of connections to the database of config
+// and checks it can be reached
+func NewPostgresStore(config DatabaseConfig) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", config.DSN)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-
-	log.Println("Connected to the database!")
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
 
 	if err := db.Ping(); err != nil {
-		return nil, err
+		db.Close()
+		return nil, fmt.Errorf("connecting to the database: %w", err)
 	}
+	log.Println("Connected to the database!")
 
 	return &PostgresStore{
 		db: db,
 	}, nil
 }
 
-func (s *PostgresStore) Init() error {
-	if err := s.createCommandTable(); err != nil {
-		return err
-	}
-
-	if err := s.createWorkersTable(); err != nil {
-		return err
+// Close closes the connections to the database, once the queries they
+// run are done. Connections still in use by then were leaked by a query
+// whose rows weren't closed, and are logged.
+func (s *PostgresStore) Close() error {
+	if stats := s.db.Stats(); stats.InUse > 0 {
+		log.Printf("closing the database with %d connections in use", stats.InUse)
 	}
-
-	return nil
+	return s.db.Close()
 }
 
-func (s *PostgresStore) createCommandTable() error {
-	query := `create table if not exists commandsss (
-		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-		fullname varchar(100) NOT NULL,
-		number varchar(100) NOT NULL,
-		flor varchar(100) NOT NULL,
-		itemtype varchar(100) NOT NULL,
-		services varchar(100) NOT NULL,
-		workers varchar(100) NOT NULL,
-		start varchar(100) NOT NULL,
-		distination varchar(100) NOT NULL,
-		isaccepted varchar(100) NOT NULL,
-		prix varchar(100) NOT NULL
-	);`
-
-	_, err := s.db.Exec(query)
-	return err
+// Init brings the database schema up to date
+func (s *PostgresStore) Init() error {
+	return s.Migrate()
 }
 
-func (s *PostgresStore) CreateCommand(acc *Command) error {
-	query := `insert into commandsss 
-	(fullname, number, flor, itemtype, services, workers, start, distination, isaccepted, prix)
-	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+// commandColumns lists the commands columns in the order scanIntoAccount reads them
+const commandColumns = `id, fullname, number, flor, elevator, itemtype, services, workers, start, distination,
+	move_date, duration_minutes, price_amount, price_currency, quote, status, created_at`
+
+func (s *PostgresStore) CreateCommand(ctx context.Context, acc *Command) error {
+	query := `insert into commands 
+	(fullname, number, flor, elevator, itemtype, services, workers, start, distination, move_date, duration_minutes, price_amount, price_currency, quote, status)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	returning id, created_at`
+
+	quote, err := jsonColumn(acc.Quote)
+	if err != nil {
+		return err
+	}
 
-	_, err := s.db.Query(
+	err = s.db.QueryRowContext(ctx,
 		query,
 		acc.FullName,
 		acc.Number,
 		acc.Flor,
+		acc.Elevator,
 		acc.Itemtype,
 		acc.Service,
 		acc.Workers,
 		acc.Start,
 		acc.Distination,
-		acc.IsAccepted,
-		acc.Prix,
-	)
+		acc.MoveDate,
+		acc.DurationMinutes,
+		acc.Prix.Amount,
+		acc.Prix.Currency,
+		quote,
+		acc.Status,
+	).Scan(&acc.ID, &acc.CreatedAt)
 
 	if err != nil {
-		return err
+		return dbError(err)
 	}
 
 	return nil
 }
 
-func (s *PostgresStore) DeleteCommand(command *Command) error {
-	result, err := s.db.Exec("DELETE FROM commandsss WHERE id = $1", command.ID)
+func (s *PostgresStore) DeleteCommand(ctx context.Context, command *Command) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM commands WHERE id = $1", command.ID)
 	if err != nil {
-		return fmt.Errorf("failed to execute delete: %w", err)
+		return dbError(fmt.Errorf("failed to execute delete: %w", err))
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -139,298 +171,1656 @@ func (s *PostgresStore) DeleteCommand(command *Command) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("no command found with ID %v", command.ID)
+		return NotFound("command_not_found", "no command found with ID %v", command.ID)
 	}
 
 	return nil
 }
 
-func (s *PostgresStore) GetCommands() ([]*Command, error) {
-	rows, err := s.db.Query("select * from commandsss ")
+func (s *PostgresStore) GetCommands(ctx context.Context, q *CommandQuery) (*Page[*Command], error) {
+	field, desc, err := parseSort(q.Sort, commandSortFields)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(q.Cursor, q.Sort, field.cast)
+	if err != nil {
+		return nil, err
+	}
+	limit := pageLimit(q.Limit)
+
+	page := &Page[*Command]{Items: []*Command{}}
+	where := q.where()
+	if err := s.db.QueryRowContext(ctx, "select count(*) from commands"+where.String(), where.args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	addCursor(where, field, desc, cursor)
+	query := "select " + commandColumns + " from commands" + where.String() + orderBy(field, desc) + fmt.Sprintf(" limit %d", limit+1)
+	rows, err := s.db.QueryContext(ctx, query, where.args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	accounts := []*Command{}
 	for rows.Next() {
 		account, err := scanIntoAccount(rows)
 		if err != nil {
 			return nil, err
 		}
-		accounts = append(accounts, account)
+		page.Items = append(page.Items, account)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	setNextCursor(page, commandID, q.Sort, field, limit)
 
-	return accounts, nil
-}
-func (s *PostgresStore) createWorkersTable() error {
-	query := `CREATE TABLE IF NOT EXISTS worker (
-    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-    fullname VARCHAR(100) NOT NULL ,
-    number VARCHAR(20) NOT NULL ,
-    email VARCHAR(100) NOT NULL UNIQUE,
-    password VARCHAR(100) NOT NULL ,
-    position VARCHAR(100) NOT NULL ,
-    experience TEXT NOT NULL ,
-    message TEXT NOT NULL ,
-    isaccepted BOOLEAN NOT NULL
-);`
-
-	_, err := s.db.Exec(query)
-	return err
+	return page, nil
 }
 
-func (s *PostgresStore) CreateWorker(worker *Worker) error {
-	hashedpassword, err := s.CreateUser(worker.Email, worker.Password)
+func (s *PostgresStore) CreateWorker(ctx context.Context, worker *Worker) error {
+	worker.Email = normalizeEmail(worker.Email)
+	hashedpassword, err := s.CreateUser(ctx, worker.Email, worker.Password)
 	if err != nil {
 		return err
 	} else {
-		query := `INSERT INTO worker (fullname, number, email, password, position, experience, message, isaccepted) 
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8); `
+		role := worker.Role
+		if role == "" {
+			role = RoleWorker
+		}
+		query := `INSERT INTO worker (fullname, number, email, password, position, experience, message, isaccepted, role, application_status) 
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+				RETURNING id, created_at, role, application_status`
 
-		_, err := s.db.Query(query, worker.FullName, worker.Number, worker.Email, hashedpassword, worker.Position, worker.Experience, worker.Message, worker.IsAccepted)
+		password := sql.NullString{String: hashedpassword, Valid: hashedpassword != ""}
+		err := s.db.QueryRowContext(ctx, query, worker.FullName, worker.Number, worker.Email, password, worker.Position, worker.Experience, worker.Message, worker.IsAccepted, role, initialApplicationStatus(worker)).
+			Scan(&worker.ID, &worker.CreatedAt, &worker.Role, &worker.ApplicationStatus)
 
-		return err
+		return dbError(err)
 	}
 }
 
-func (s *PostgresStore) GetWorkers() ([]*Worker, error) {
-	rows, err := s.db.Query("select * from worker ")
+// workerColumns lists the worker columns in the order scanIntoWorker reads them
+const workerColumns = `id, fullname, number, email, coalesce(password, ''), position, experience, message, isaccepted,
+	created_at, role, application_status`
+
+func (s *PostgresStore) GetWorkers(ctx context.Context, q *WorkerQuery) (*Page[*Worker], error) {
+	field, desc, err := parseSort(q.Sort, workerSortFields)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := decodeCursor(q.Cursor, q.Sort, field.cast)
+	if err != nil {
+		return nil, err
+	}
+	limit := pageLimit(q.Limit)
+
+	page := &Page[*Worker]{Items: []*Worker{}}
+	where := q.where()
+	if err := s.db.QueryRowContext(ctx, "select count(*) from worker"+where.String(), where.args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	addCursor(where, field, desc, cursor)
+	query := "select " + workerColumns + " from worker" + where.String() + orderBy(field, desc) + fmt.Sprintf(" limit %d", limit+1)
+	rows, err := s.db.QueryContext(ctx, query, where.args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	Workers := []*Worker{}
 	for rows.Next() {
 		worker, err := scanIntoWorker(rows)
 		if err != nil {
 			return nil, err
 		}
-		Workers = append(Workers, worker)
+		page.Items = append(page.Items, worker)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
+	setNextCursor(page, workerID, q.Sort, field, limit)
 
-	return Workers, nil
+	return page, nil
 }
 
-// func (s *PostgresStore) Register(password string, email string) (bool, error) {
-// 	var hashedPassword string
-
-// 	// Fetch the hashed password from the database
-// 	query := `SELECT password FROM worker WHERE email = $1`
-// 	err := s.db.QueryRow(query, email).Scan(&hashedPassword)
-// 	if err != nil {
-// 		if errors.Is(err, sql.ErrNoRows) {
-// 			// User not found
-// 			return false, nil
-// 		}
-// 		// Other DB error
-// 		return false, err
-// 	}
+// Register returns the worker with email if password is theirs, and
+// ErrInvalidCredentials otherwise
+func (s *PostgresStore) Register(ctx context.Context, password string, email string) (*Worker, error) {
+	worker, err := s.GetWorkerByEmail(ctx, email)
+	return authenticate(worker, err, password)
+}
 
-// 	// Compare the plaintext password with the stored hash
-// 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-// 	if err != nil {
-// 		// Password mismatch
-// 		return false, nil
-// 	}
+func (s *PostgresStore) GetWorkerByEmail(ctx context.Context, email string) (*Worker, error) {
+	rows, err := s.db.QueryContext(ctx, "select "+workerColumns+" from worker where email = $1", normalizeEmail(email))
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
 
-// 	// Password matches
-// 	return true, nil
-// }
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, NotFound("worker_not_found", "Worker %s not found", email)
+	}
+	return scanIntoWorker(rows)
+}
 
-func (s *PostgresStore) Register(password string, email string) (*Worker, error) {
-	worker, err := s.GetWorkerByEmail(email)
+func (s *PostgresStore) GetAccountByID(ctx context.Context, id string) (*Worker, error) {
+	rows, err := s.db.QueryContext(ctx, "select "+workerColumns+" from worker where id = $1", id)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, NotFound("worker_not_found", "account %s not found", id)
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(worker.Password), []byte(password))
+	return scanIntoWorker(rows)
+}
+
+func (s *PostgresStore) GetCommandByID(ctx context.Context, id string) (*Command, error) {
+	rows, err := s.db.QueryContext(ctx, "select "+commandColumns+" from commands where id = $1", id)
 	if err != nil {
-		// Password mismatch
-		return nil, nil
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, NotFound("command_not_found", "command %s not found", id)
 	}
+	return scanIntoAccount(rows)
+}
 
-	return worker, nil
+// UpdateCommand moves the command to command.Status, subject to the
+// lifecycle rules enforced by TransitionCommand
+func (s *PostgresStore) UpdateCommand(ctx context.Context, command *Command) error {
+	_, err := s.TransitionCommand(ctx, command.ID, command.Status, "", "")
+	return err
 }
 
-func (s *PostgresStore) GetWorkerByEmail(email string) (*Worker, error) {
-	rows, err := s.db.Query("select * from worker where email = $1", email)
+// TransitionCommand moves a command to a new status and records the change
+// in command_status_history, rejecting moves the lifecycle doesn't allow
+func (s *PostgresStore) TransitionCommand(ctx context.Context, id string, to CommandStatus, changedBy, note string) (*Command, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	for rows.Next() {
-		return scanIntoWorker(rows)
+	var from CommandStatus
+	err = tx.QueryRowContext(ctx, `SELECT status FROM commands WHERE id = $1 FOR UPDATE`, id).Scan(&from)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, NotFound("command_not_found", "no command found with ID %s", id)
+	}
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	if err := recordTransition(ctx, tx, id, from, to, changedBy, note); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetCommandByID(ctx, id)
+}
+
+// recordTransition moves a command locked by tx from one status to the
+// other, if the lifecycle allows it, and records the change in its history
+func recordTransition(ctx context.Context, tx *sql.Tx, id string, from, to CommandStatus, changedBy, note string) error {
+	if err := checkTransition(from, to); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE commands SET status = $1 WHERE id = $2`, to, id); err != nil {
+		return fmt.Errorf("failed to execute update query: %w", err)
 	}
 
-	return nil, fmt.Errorf("Worker %s not found", email)
+	query := `INSERT INTO command_status_history (command_id, from_status, to_status, changed_by, note)
+		VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, query, id, from, to, sql.NullString{String: changedBy, Valid: changedBy != ""}, note); err != nil {
+		return fmt.Errorf("failed to record status change: %w", err)
+	}
+	return nil
 }
 
-func (s *PostgresStore) GetAccountByID(id string) (*Worker, error) {
-	rows, err := s.db.Query("select * from worker where id = $1", id)
+// PatchCommand updates the fields set in patch. A status other than the
+// current one is a transition, checked and recorded like TransitionCommand.
+func (s *PostgresStore) PatchCommand(ctx context.Context, id string, patch *CommandPatch, changedBy string) (*Command, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	for rows.Next() {
-		return scanIntoWorker(rows)
+	var from CommandStatus
+	err = tx.QueryRowContext(ctx, `SELECT status FROM commands WHERE id = $1 FOR UPDATE`, id).Scan(&from)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, NotFound("command_not_found", "no command found with ID %s", id)
+	}
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	set := new(sqlSet)
+	setColumn(set, "fullname", patch.FullName)
+	setColumn(set, "number", patch.Number)
+	setColumn(set, "flor", patch.Flor)
+	setColumn(set, "elevator", patch.Elevator)
+	setColumn(set, "itemtype", patch.Itemtype)
+	setColumn(set, "services", patch.Service)
+	setColumn(set, "workers", patch.Workers)
+	setColumn(set, "start", patch.Start)
+	setColumn(set, "distination", patch.Distination)
+	setColumn(set, "move_date", patch.MoveDate)
+	setColumn(set, "duration_minutes", patch.DurationMinutes)
+	if patch.Prix != nil {
+		setColumn(set, "price_amount", &patch.Prix.Amount)
+		setColumn(set, "price_currency", &patch.Prix.Currency)
+	}
+	if !set.empty() {
+		query := "UPDATE commands" + set.String() + fmt.Sprintf(" WHERE id = $%d", len(set.args)+1)
+		if _, err := tx.ExecContext(ctx, query, append(set.args, id)...); err != nil {
+			return nil, dbError(fmt.Errorf("failed to execute update query: %w", err))
+		}
+	}
+
+	if patch.Status != nil && *patch.Status != from {
+		if err := recordTransition(ctx, tx, id, from, *patch.Status, changedBy, patch.Note); err != nil {
+			return nil, err
+		}
+	}
+
+	// A rescheduled job may now overlap other jobs of its crew
+	if patch.MoveDate != nil || patch.DurationMinutes != nil {
+		if err := checkCrewAvailability(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("account %s not found", id)
+	return s.GetCommandByID(ctx, id)
 }
 
-func (s *PostgresStore) GetCommandByID(id string) (*Command, error) {
-	rows, err := s.db.Query("select * from commandsss where id = $1", id)
+func (s *PostgresStore) GetCommandHistory(ctx context.Context, id string) ([]*CommandStatusChange, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, command_id, from_status, to_status, coalesce(changed_by, ''), note, changed_at
+		FROM command_status_history WHERE command_id = $1 ORDER BY changed_at, id`, id)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
+	defer rows.Close()
 
+	changes := []*CommandStatusChange{}
 	for rows.Next() {
-		return scanIntoAccount(rows)
+		change := new(CommandStatusChange)
+		if err := rows.Scan(
+			&change.ID,
+			&change.CommandID,
+			&change.FromStatus,
+			&change.ToStatus,
+			&change.ChangedBy,
+			&change.Note,
+			&change.ChangedAt,
+		); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
 	}
 
-	return nil, fmt.Errorf("command %s not found", id)
+	return changes, rows.Err()
 }
 
-func (s *PostgresStore) UpdateCommand(command *Command) error {
+// TransitionApplication moves a worker's application to another status,
+// if the pipeline allows it, and records the decision. Hiring accepts the
+// worker.
+func (s *PostgresStore) TransitionApplication(ctx context.Context, id string, to ApplicationStatus, changedBy, note string) (*Worker, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	query := `
-		UPDATE commandsss
-		SET isaccepted = $1
-		WHERE id = $2
-	`
-	result, err := s.db.Exec(query, command.IsAccepted, command.ID)
+	var from ApplicationStatus
+	err = tx.QueryRowContext(ctx, `SELECT application_status FROM worker WHERE id = $1 FOR UPDATE`, id).Scan(&from)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, NotFound("worker_not_found", "no worker found with ID %s", id)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to execute update query: %w", err)
+		return nil, dbError(err)
 	}
-	rowsAffected, err := result.RowsAffected()
+	if err := checkApplicationTransition(from, to); err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE worker SET application_status = $1, isaccepted = isaccepted OR $1 = $2 WHERE id = $3`
+	if _, err := tx.ExecContext(ctx, query, to, ApplicationHired, id); err != nil {
+		return nil, fmt.Errorf("failed to execute update query: %w", err)
+	}
+
+	query = `INSERT INTO application_status_history (worker_id, from_status, to_status, changed_by, note)
+		VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, query, id, from, to, sql.NullString{String: changedBy, Valid: changedBy != ""}, note); err != nil {
+		return nil, fmt.Errorf("failed to record status change: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetAccountByID(ctx, id)
+}
+
+func (s *PostgresStore) GetApplicationHistory(ctx context.Context, workerID string) ([]*ApplicationStatusChange, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, worker_id, from_status, to_status, coalesce(changed_by::text, ''), note, changed_at
+		FROM application_status_history WHERE worker_id = $1 ORDER BY changed_at, id`, workerID)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve affected rows: %w", err)
+		return nil, dbError(err)
 	}
+	defer rows.Close()
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("no command found with ID %s", command.ID)
+	changes := []*ApplicationStatusChange{}
+	for rows.Next() {
+		change := new(ApplicationStatusChange)
+		if err := rows.Scan(
+			&change.ID,
+			&change.WorkerID,
+			&change.FromStatus,
+			&change.ToStatus,
+			&change.ChangedBy,
+			&change.Note,
+			&change.ChangedAt,
+		); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
 	}
 
-	return nil
+	return changes, rows.Err()
 }
 
-func (s *PostgresStore) UpdateWorker(worker *Worker) error {
+func (s *PostgresStore) AddApplicationNote(ctx context.Context, note *ApplicationNote) error {
+	query := `INSERT INTO application_notes (worker_id, author_id, body) VALUES ($1, $2, $3) RETURNING id, created_at`
+	err := s.db.QueryRowContext(ctx, query, note.WorkerID, sql.NullString{String: note.AuthorID, Valid: note.AuthorID != ""}, note.Body).
+		Scan(&note.ID, &note.CreatedAt)
 
-	query := `
-		UPDATE worker
-		SET isaccepted = $1
-		WHERE id = $2
-	`
-	result, err := s.db.Exec(query, worker.IsAccepted, worker.ID)
-	if err != nil {
-		return fmt.Errorf("failed to execute update query: %w", err)
+	var apiErr *Error
+	if errors.As(dbError(err), &apiErr) && apiErr.Code == "reference_conflict" {
+		return NotFound("worker_not_found", "no worker found with ID %s", note.WorkerID)
 	}
-	rowsAffected, err := result.RowsAffected()
+	return dbError(err)
+}
+
+func (s *PostgresStore) GetApplicationNotes(ctx context.Context, workerID string) ([]*ApplicationNote, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, worker_id, coalesce(author_id::text, ''), body, created_at
+		FROM application_notes WHERE worker_id = $1 ORDER BY created_at, id`, workerID)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve affected rows: %w", err)
+		return nil, dbError(err)
 	}
+	defer rows.Close()
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("no worker found with ID %s", worker.ID)
+	notes := []*ApplicationNote{}
+	for rows.Next() {
+		note := new(ApplicationNote)
+		if err := rows.Scan(&note.ID, &note.WorkerID, &note.AuthorID, &note.Body, &note.CreatedAt); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
 	}
 
-	return nil
+	return notes, rows.Err()
 }
 
-func scanIntoAccount(rows *sql.Rows) (*Command, error) {
-	command := new(Command)
-	err := rows.Scan(
-		&command.ID,
-		&command.FullName,
-		&command.Number,
-		&command.Flor,
-		&command.Itemtype,
-		&command.Service,
-		&command.Workers,
-		&command.Start,
-		&command.Distination,
-		&command.IsAccepted,
-		&command.Prix,
-	)
+// CreateInvitation stores an invitation, invalidating the worker's earlier
+// unused ones
+func (s *PostgresStore) CreateInvitation(ctx context.Context, inv *Invitation) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM worker_invitations WHERE worker_id = $1 AND used_at IS NULL`, inv.WorkerID); err != nil {
+		return dbError(err)
+	}
+	query := `INSERT INTO worker_invitations (worker_id, created_by, expires_at)
+		VALUES ($1, $2, $3) RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, query, inv.WorkerID, sql.NullString{String: inv.CreatedBy, Valid: inv.CreatedBy != ""}, inv.ExpiresAt).
+		Scan(&inv.ID, &inv.CreatedAt)
+	if err != nil {
+		return dbError(err)
+	}
 
-	return command, err
+	return tx.Commit()
 }
 
-func scanIntoWorker(rows *sql.Rows) (*Worker, error) {
-	worker := new(Worker)
-	err := rows.Scan(
-		&worker.ID,
-		&worker.FullName,
-		&worker.Number,
-		&worker.Email,
-		&worker.Password,
-		&worker.Position,
-		&worker.Experience,
-		&worker.Message,
-		&worker.IsAccepted)
+// ActivateAccount uses an invitation to set its worker's password
+func (s *PostgresStore) ActivateAccount(ctx context.Context, invitationID, workerID, password string) error {
+	hash, err := newPasswordHash(password)
+	if err != nil {
+		return err
+	}
 
-	return worker, err
-}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `SELECT expires_at, used_at FROM worker_invitations
+		WHERE id = $1 AND worker_id = $2 FOR UPDATE`, invitationID, workerID).Scan(&expiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) || KindOf(dbError(err)) == KindNotFound {
+		// Replaced by a newer invitation, or never issued
+		return ErrInvalidActivation
+	}
+	if err != nil {
+		return err
+	}
+	if usedAt.Valid {
+		return ErrActivationLinkUsed
+	}
+	if !time.Now().Before(expiresAt) {
+		return ErrInvalidActivation
+	}
 
-func (s *PostgresStore) createAdminTable() error {
-	query := `CREATE TABLE IF NOT EXISTS worker (
-    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-    admin VARCHAR(100) NOT NULL UNIQUE ,
-    password VARCHAR(100) NOT NULL ,
-);`
+	worker, err := lockWorker(ctx, tx, workerID)
+	if err != nil {
+		return err
+	}
+	if !worker.IsAccepted {
+		return ErrAccountInactive
+	}
 
-	_, err := s.db.Exec(query)
-	return err
-}
+	if _, err := tx.ExecContext(ctx, `UPDATE worker SET password = $1 WHERE id = $2`, hash, workerID); err != nil {
+		return fmt.Errorf("failed to execute update query: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE worker_invitations SET used_at = now() WHERE id = $1`, invitationID); err != nil {
+		return fmt.Errorf("failed to execute update query: %w", err)
+	}
 
-func (s *PostgresStore) DropTable(tableName string) error {
-	query := fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE", tableName)
+	return tx.Commit()
+}
 
-	_, err := s.db.Exec(query)
+// CreatePasswordReset stores a reset token, invalidating the worker's
+// earlier unused ones
+func (s *PostgresStore) CreatePasswordReset(ctx context.Context, reset *PasswordReset) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to drop table %s: %w", tableName, err)
+		return err
 	}
+	defer tx.Rollback()
 
-	fmt.Printf("Table %s dropped successfully.\n", tableName)
-	return nil
+	if _, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE worker_id = $1 AND used_at IS NULL`, reset.WorkerID); err != nil {
+		return dbError(err)
+	}
+	query := `INSERT INTO password_resets (worker_id, token_hash, expires_at)
+		VALUES ($1, $2, $3) RETURNING id, created_at`
+	if err := tx.QueryRowContext(ctx, query, reset.WorkerID, reset.TokenHash, reset.ExpiresAt).Scan(&reset.ID, &reset.CreatedAt); err != nil {
+		return dbError(err)
+	}
+
+	return tx.Commit()
 }
 
-func (s *PostgresStore) DropAllTables() error {
-	// Query to list all user-defined tables in the public schema
-	rows, err := s.db.Query(`
-		SELECT tablename
-		FROM pg_tables
-		WHERE schemaname = 'public'
-	`)
+// ResetPassword uses the reset token with the given hash to set its
+// worker's password, returning the worker's id
+func (s *PostgresStore) ResetPassword(ctx context.Context, tokenHash, password string) (string, error) {
+	hash, err := newPasswordHash(password)
 	if err != nil {
-		return fmt.Errorf("failed to list tables: %w", err)
+		return "", err
 	}
-	defer rows.Close()
 
-	var tableName string
-	var tables []string
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Using the token in the same statement that finds it makes it
+	// single-use even under concurrent requests
+	var workerID string
+	err = tx.QueryRowContext(ctx, `UPDATE password_resets SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING worker_id`, tokenHash).Scan(&workerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrInvalidResetToken
+	}
+	if err != nil {
+		return "", dbError(err)
+	}
 
-	for rows.Next() {
-		if err := rows.Scan(&tableName); err != nil {
-			return fmt.Errorf("failed to scan table name: %w", err)
-		}
-		tables = append(tables, tableName)
+	if _, err := tx.ExecContext(ctx, `UPDATE worker SET password = $1 WHERE id = $2`, hash, workerID); err != nil {
+		return "", fmt.Errorf("failed to execute update query: %w", err)
 	}
 
-	if len(tables) == 0 {
-		fmt.Println("No tables found to drop.")
-		return nil
+	return workerID, tx.Commit()
+}
+
+func (s *PostgresStore) UpdateWorker(ctx context.Context, worker *Worker) error {
+
+	query := `
+		UPDATE worker
+		SET isaccepted = $1
+		WHERE id = $2
+	`
+	result, err := s.db.ExecContext(ctx, query, worker.IsAccepted, worker.ID)
+	if err != nil {
+		return dbError(fmt.Errorf("failed to execute update query: %w", err))
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve affected rows: %w", err)
 	}
 
-	// Drop each table
-	for _, table := range tables {
-		query := fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE", table)
-		_, err := s.db.Exec(query)
-		if err != nil {
-			return fmt.Errorf("failed to drop table %s: %w", table, err)
-		}
-		fmt.Printf("Dropped table: %s\n", table)
+	if rowsAffected == 0 {
+		return NotFound("worker_not_found", "no worker found with ID %s", worker.ID)
 	}
 
-	fmt.Println("All tables dropped successfully.")
 	return nil
 }
+
+// PatchWorker updates the fields set in patch
+func (s *PostgresStore) PatchWorker(ctx context.Context, id string, patch *WorkerPatch) (*Worker, error) {
+	set := new(sqlSet)
+	setColumn(set, "fullname", patch.FullName)
+	setColumn(set, "number", patch.Number)
+	setColumn(set, "position", patch.Position)
+	setColumn(set, "experience", patch.Experience)
+	setColumn(set, "message", patch.Message)
+	setColumn(set, "isaccepted", patch.IsAccepted)
+	if set.empty() {
+		return s.GetAccountByID(ctx, id)
+	}
+
+	query := "UPDATE worker" + set.String() + fmt.Sprintf(" WHERE id = $%d", len(set.args)+1)
+	result, err := s.db.ExecContext(ctx, query, append(set.args, id)...)
+	if err != nil {
+		return nil, dbError(fmt.Errorf("failed to execute update query: %w", err))
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return nil, NotFound("worker_not_found", "no worker found with ID %s", id)
+	}
+
+	return s.GetAccountByID(ctx, id)
+}
+
+// DeleteWorker deletes a worker along with their sessions
+func (s *PostgresStore) DeleteWorker(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM worker WHERE id = $1", id)
+	if err != nil {
+		return dbError(fmt.Errorf("failed to execute delete: %w", err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return NotFound("worker_not_found", "no worker found with ID %s", id)
+	}
+
+	return nil
+}
+
+// querier is what *sql.DB and *sql.Tx have in common, for queries run
+// inside or outside a transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// lockCommand reads a command, locking it until tx ends
+func lockCommand(ctx context.Context, tx *sql.Tx, id string) (*Command, error) {
+	rows, err := tx.QueryContext(ctx, "select "+commandColumns+" from commands where id = $1 FOR UPDATE", id)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, NotFound("command_not_found", "command %s not found", id)
+	}
+	return scanIntoAccount(rows)
+}
+
+// lockWorker reads a worker, locking it until tx ends so concurrent
+// bookings of the same worker are checked one after the other
+func lockWorker(ctx context.Context, tx *sql.Tx, id string) (*Worker, error) {
+	rows, err := tx.QueryContext(ctx, "select "+workerColumns+" from worker where id = $1 FOR UPDATE", id)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, NotFound("worker_not_found", "account %s not found", id)
+	}
+	return scanIntoWorker(rows)
+}
+
+const assignmentColumns = `id, command_id, worker_id, role, coalesce(assigned_by::text, ''), assigned_at`
+
+func queryAssignments(ctx context.Context, q querier, where string, args ...any) ([]*Assignment, error) {
+	rows, err := q.QueryContext(ctx, "select "+assignmentColumns+" from command_assignments where "+where+" order by assigned_at, id", args...)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	assignments := []*Assignment{}
+	for rows.Next() {
+		a := new(Assignment)
+		if err := rows.Scan(&a.ID, &a.CommandID, &a.WorkerID, &a.Role, &a.AssignedBy, &a.AssignedAt); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, rows.Err()
+}
+
+// findOverlappingJob returns the id of a job other than commandID that
+// keeps the worker busy between start and end, or ""
+func findOverlappingJob(ctx context.Context, q querier, workerID, commandID string, start, end time.Time) (string, error) {
+	query := `SELECT c.id FROM command_assignments a JOIN commands c ON c.id = a.command_id
+		WHERE a.worker_id = $1 AND c.id <> $2
+			AND NOT (c.status = ANY($3))
+			AND c.move_date < $5
+			AND c.move_date + c.duration_minutes * interval '1 minute' > $4
+		ORDER BY c.move_date LIMIT 1`
+
+	var id string
+	err := q.QueryRowContext(ctx, query, workerID, commandID, pq.Array(finalStatuses()), start, end).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return id, err
+}
+
+// checkCrewAvailability makes sure none of the crew of a command locked
+// by tx is booked elsewhere during it
+func checkCrewAvailability(ctx context.Context, tx *sql.Tx, commandID string) error {
+	command, err := lockCommand(ctx, tx, commandID)
+	if err != nil {
+		return err
+	}
+	if command.MoveDate == nil || !command.busy() {
+		return nil
+	}
+
+	crew, err := queryAssignments(ctx, tx, "command_id = $1", commandID)
+	if err != nil {
+		return err
+	}
+	start, end := command.window()
+	for _, member := range crew {
+		other, err := findOverlappingJob(ctx, tx, member.WorkerID, commandID, start, end)
+		if err != nil {
+			return err
+		}
+		if other != "" {
+			return doubleBooked(member.WorkerID, other)
+		}
+	}
+	return nil
+}
+
+// AssignWorker adds a worker to a command's crew, refusing workers who
+// aren't accepted or are booked on an overlapping job
+func (s *PostgresStore) AssignWorker(ctx context.Context, a *Assignment) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	command, err := lockCommand(ctx, tx, a.CommandID)
+	if err != nil {
+		return err
+	}
+	worker, err := lockWorker(ctx, tx, a.WorkerID)
+	if err != nil {
+		return err
+	}
+	crew, err := queryAssignments(ctx, tx, "command_id = $1", a.CommandID)
+	if err != nil {
+		return err
+	}
+	if err := checkAssignment(command, worker, crew, a); err != nil {
+		return err
+	}
+
+	start, end := command.window()
+	other, err := findOverlappingJob(ctx, tx, a.WorkerID, a.CommandID, start, end)
+	if err != nil {
+		return err
+	}
+	if other != "" {
+		return doubleBooked(a.WorkerID, other)
+	}
+	onTimeOff, err := hasTimeOff(ctx, tx, a.WorkerID, start, end)
+	if err != nil {
+		return err
+	}
+	if onTimeOff {
+		return ErrWorkerOnTimeOff
+	}
+
+	query := `INSERT INTO command_assignments (command_id, worker_id, role, assigned_by)
+		VALUES ($1, $2, $3, $4) RETURNING id, assigned_at`
+	err = tx.QueryRowContext(ctx, query, a.CommandID, a.WorkerID, a.Role, sql.NullString{String: a.AssignedBy, Valid: a.AssignedBy != ""}).
+		Scan(&a.ID, &a.AssignedAt)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) UnassignWorker(ctx context.Context, commandID, workerID string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM command_assignments WHERE command_id = $1 AND worker_id = $2`, commandID, workerID)
+	if err != nil {
+		return dbError(fmt.Errorf("failed to execute delete: %w", err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return NotFound("assignment_not_found", "worker %s is not assigned to command %s", workerID, commandID)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetAssignments(ctx context.Context, commandID string) ([]*Assignment, error) {
+	return queryAssignments(ctx, s.db, "command_id = $1", commandID)
+}
+
+// GetWorkerJobs lists a worker's assignments with their commands, by
+// move date
+func (s *PostgresStore) GetWorkerJobs(ctx context.Context, workerID string) ([]*Job, error) {
+	assignments, err := queryAssignments(ctx, s.db, "worker_id = $1", workerID)
+	if err != nil {
+		return nil, err
+	}
+	byCommand := make(map[string]*Assignment, len(assignments))
+	for _, a := range assignments {
+		byCommand[a.CommandID] = a
+	}
+
+	rows, err := s.db.QueryContext(ctx, "select "+commandColumns+` from commands
+		where id in (select command_id from command_assignments where worker_id = $1)
+		order by move_date nulls last, id`, workerID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		command, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		// An assignment made between the two queries has no entry yet
+		if a, ok := byCommand[command.ID]; ok {
+			jobs = append(jobs, &Job{Assignment: a, Command: command})
+		}
+	}
+
+	return jobs, rows.Err()
+}
+
+// hasTimeOff reports whether the worker has approved time off overlapping
+// start to end
+func hasTimeOff(ctx context.Context, q querier, workerID string, start, end time.Time) (bool, error) {
+	var found bool
+	err := q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM time_off
+		WHERE worker_id = $1 AND status = $2 AND starts_at < $4 AND ends_at > $3)`,
+		workerID, TimeOffApproved, start, end).Scan(&found)
+	return found, err
+}
+
+// queryAvailability loads the weekly slots of the given workers, keyed by
+// worker id
+func queryAvailability(ctx context.Context, q querier, workerIDs []string) (map[string][]AvailabilitySlot, error) {
+	rows, err := q.QueryContext(ctx, `SELECT worker_id, weekday, start_minute, end_minute FROM worker_availability
+		WHERE worker_id = ANY($1::uuid[]) ORDER BY worker_id, weekday, start_minute`, pq.Array(workerIDs))
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	slots := make(map[string][]AvailabilitySlot)
+	for rows.Next() {
+		var workerID string
+		var weekday time.Weekday
+		var start, end int
+		if err := rows.Scan(&workerID, &weekday, &start, &end); err != nil {
+			return nil, err
+		}
+		slots[workerID] = append(slots[workerID], AvailabilitySlot{
+			Weekday: strings.ToLower(weekday.String()),
+			Start:   fmt.Sprintf("%02d:%02d", start/60, start%60),
+			End:     fmt.Sprintf("%02d:%02d", end/60, end%60),
+		})
+	}
+	return slots, rows.Err()
+}
+
+func (s *PostgresStore) GetAvailability(ctx context.Context, workerID string) ([]AvailabilitySlot, error) {
+	slots, err := queryAvailability(ctx, s.db, []string{workerID})
+	if err != nil {
+		return nil, err
+	}
+	if slots[workerID] == nil {
+		return []AvailabilitySlot{}, nil
+	}
+	return slots[workerID], nil
+}
+
+// SetAvailability replaces the worker's weekly slots
+func (s *PostgresStore) SetAvailability(ctx context.Context, workerID string, slots []AvailabilitySlot) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM worker_availability WHERE worker_id = $1`, workerID); err != nil {
+		return dbError(err)
+	}
+	for _, slot := range slots {
+		start, end, _ := slot.minutes()
+		_, err := tx.ExecContext(ctx, `INSERT INTO worker_availability (worker_id, weekday, start_minute, end_minute)
+			VALUES ($1, $2, $3, $4)`, workerID, int(weekdays[slot.Weekday]), start, end)
+		if err != nil {
+			return dbError(err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+const timeOffColumns = `id, worker_id, starts_at, ends_at, reason, status, coalesce(reviewed_by::text, ''), reviewed_at, created_at`
+
+func scanIntoTimeOff(row interface{ Scan(...any) error }) (*TimeOff, error) {
+	t := new(TimeOff)
+	var reviewedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.WorkerID, &t.Start, &t.End, &t.Reason, &t.Status, &t.ReviewedBy, &reviewedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if reviewedAt.Valid {
+		t.ReviewedAt = &reviewedAt.Time
+	}
+	return t, nil
+}
+
+func (s *PostgresStore) CreateTimeOff(ctx context.Context, t *TimeOff) error {
+	query := `INSERT INTO time_off (worker_id, starts_at, ends_at, reason, status)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err := s.db.QueryRowContext(ctx, query, t.WorkerID, t.Start, t.End, t.Reason, t.Status).Scan(&t.ID, &t.CreatedAt)
+	return dbError(err)
+}
+
+func (s *PostgresStore) GetTimeOff(ctx context.Context, q *TimeOffQuery) ([]*TimeOff, error) {
+	where := new(sqlWhere)
+	if q.WorkerID != "" {
+		where.add("worker_id = ?", q.WorkerID)
+	}
+	if q.Status != "" {
+		where.add("status = ?", q.Status)
+	}
+
+	rows, err := s.db.QueryContext(ctx, "select "+timeOffColumns+" from time_off"+where.String()+" order by starts_at, id", where.args...)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	timeOff := []*TimeOff{}
+	for rows.Next() {
+		t, err := scanIntoTimeOff(rows)
+		if err != nil {
+			return nil, err
+		}
+		timeOff = append(timeOff, t)
+	}
+	return timeOff, rows.Err()
+}
+
+// ReviewTimeOff approves or rejects a pending time-off request
+func (s *PostgresStore) ReviewTimeOff(ctx context.Context, id string, status TimeOffStatus, reviewedBy string) (*TimeOff, error) {
+	query := `UPDATE time_off SET status = $2, reviewed_by = $3, reviewed_at = now()
+		WHERE id = $1 AND status = $4 RETURNING ` + timeOffColumns
+	t, err := scanIntoTimeOff(s.db.QueryRowContext(ctx, query, id, status, sql.NullString{String: reviewedBy, Valid: reviewedBy != ""}, TimeOffPending))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return t, dbError(err)
+	}
+
+	// Tell a missing request from one already reviewed
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM time_off WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return nil, dbError(err)
+	}
+	if !exists {
+		return nil, NotFound("time_off_not_found", "time off %s not found", id)
+	}
+	return nil, ErrTimeOffReviewed
+}
+
+// GetAvailableWorkers narrows down the accepted workers in SQL to those
+// without approved time off or overlapping jobs, then checks their weekly
+// slots cover the window
+func (s *PostgresStore) GetAvailableWorkers(ctx context.Context, q *AvailabilityQuery) ([]*Worker, error) {
+	where := new(sqlWhere)
+	where.add("isaccepted")
+	if q.Position != "" {
+		where.add("position = ?", q.Position)
+	}
+	where.add(`NOT EXISTS (SELECT 1 FROM time_off t
+		WHERE t.worker_id = worker.id AND t.status = ? AND t.starts_at < ? AND t.ends_at > ?)`,
+		TimeOffApproved, q.To, q.From)
+	where.add(`NOT EXISTS (SELECT 1 FROM command_assignments a JOIN commands c ON c.id = a.command_id
+		WHERE a.worker_id = worker.id AND NOT (c.status = ANY(?))
+			AND c.move_date < ? AND c.move_date + c.duration_minutes * interval '1 minute' > ?)`,
+		pq.Array(finalStatuses()), q.To, q.From)
+
+	rows, err := s.db.QueryContext(ctx, "select "+workerColumns+" from worker"+where.String()+` order by fullname COLLATE "C", id`, where.args...)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	candidates := []*Worker{}
+	for rows.Next() {
+		worker, err := scanIntoWorker(rows)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, worker)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(candidates))
+	for i, worker := range candidates {
+		ids[i] = worker.ID
+	}
+	slots, err := queryAvailability(ctx, s.db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := []*Worker{}
+	for _, worker := range candidates {
+		if coversWindow(slots[worker.ID], q.From, q.To) {
+			workers = append(workers, worker)
+		}
+	}
+	return workers, nil
+}
+
+// GetRateCard returns the latest version of the rate card
+func (s *PostgresStore) GetRateCard(ctx context.Context) (*RateCard, error) {
+	var (
+		id        int64
+		data      []byte
+		createdBy sql.NullString
+		createdAt time.Time
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT id, card, created_by, created_at FROM rate_cards ORDER BY id DESC LIMIT 1`).
+		Scan(&id, &data, &createdBy, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, NotFound("rate_card_not_found", "no rate card has been saved")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	card := new(RateCard)
+	if err := json.Unmarshal(data, card); err != nil {
+		return nil, fmt.Errorf("invalid rate card %d: %w", id, err)
+	}
+	card.ID, card.CreatedBy, card.CreatedAt = id, createdBy.String, createdAt
+
+	return card, nil
+}
+
+// SaveRateCard adds card as the latest version of the rate card
+func (s *PostgresStore) SaveRateCard(ctx context.Context, card *RateCard) error {
+	data, err := jsonColumn(card)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO rate_cards (card, created_by) VALUES ($1, $2) RETURNING id, created_at`
+	err = s.db.QueryRowContext(ctx, query, data, sql.NullString{String: card.CreatedBy, Valid: card.CreatedBy != ""}).
+		Scan(&card.ID, &card.CreatedAt)
+	return dbError(err)
+}
+
+const loginThrottleColumns = `scope, key, failures, last_failure_at`
+
+// recentFailure is true of throttles with failures, the last within
+// loginFailureWindow, whose length is the query's first parameter
+const recentFailure = `failures > 0 AND last_failure_at > now() - make_interval(secs => $1)`
+
+func scanIntoLoginThrottle(row interface{ Scan(...any) error }) (*LoginThrottle, error) {
+	t := new(LoginThrottle)
+	err := row.Scan(&t.Scope, &t.Key, &t.Failures, &t.LastFailureAt)
+	return t, err
+}
+
+func (s *PostgresStore) GetLoginThrottle(ctx context.Context, scope ThrottleScope, key string) (*LoginThrottle, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+loginThrottleColumns+" FROM login_throttles WHERE "+recentFailure+" AND scope = $2 AND key = $3",
+		loginFailureWindow.Seconds(), scope, key)
+
+	t, err := scanIntoLoginThrottle(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrThrottleNotFound
+	}
+	return t, err
+}
+
+func (s *PostgresStore) GetLoginThrottles(ctx context.Context) ([]*LoginThrottle, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+loginThrottleColumns+" FROM login_throttles WHERE "+recentFailure+" ORDER BY last_failure_at DESC",
+		loginFailureWindow.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	throttles := []*LoginThrottle{}
+	for rows.Next() {
+		t, err := scanIntoLoginThrottle(rows)
+		if err != nil {
+			return nil, err
+		}
+		throttles = append(throttles, t)
+	}
+
+	return throttles, rows.Err()
+}
+
+// ReserveLoginAttempt counts a login attempt as a failure against scope
+// and key, the count starting over when the last failure is older than
+// loginFailureWindow, unless they are locked out: the throttle is then
+// returned with LockedUntil set and nothing is counted. The row stays
+// locked in between, so parallel attempts are counted one after the
+// other. Forgotten throttles are deleted on the way.
+func (s *PostgresStore) ReserveLoginAttempt(ctx context.Context, scope ThrottleScope, key string) (*LoginThrottle, error) {
+	window := loginFailureWindow.Seconds()
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM login_throttles WHERE NOT ("+recentFailure+")", window); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Parallel first attempts wait on the primary key here, then lock the
+	// row one after the other below
+	insert := `INSERT INTO login_throttles (scope, key, failures, last_failure_at)
+		VALUES ($1, $2, 0, now())
+		ON CONFLICT (scope, key) DO NOTHING`
+	if _, err := tx.ExecContext(ctx, insert, scope, key); err != nil {
+		return nil, err
+	}
+	query := `SELECT scope, key, CASE WHEN ` + recentFailure + ` THEN failures ELSE 0 END, last_failure_at
+		FROM login_throttles
+		WHERE scope = $2 AND key = $3
+		FOR UPDATE`
+	t, err := scanIntoLoginThrottle(tx.QueryRowContext(ctx, query, window, scope, key))
+	if err != nil {
+		return nil, err
+	}
+	if t.setLock(time.Now()); t.LockedUntil != nil {
+		return t, tx.Commit()
+	}
+
+	update := `UPDATE login_throttles SET failures = $3, last_failure_at = now()
+		WHERE scope = $1 AND key = $2
+		RETURNING ` + loginThrottleColumns
+	if t, err = scanIntoLoginThrottle(tx.QueryRowContext(ctx, update, scope, key, t.Failures+1)); err != nil {
+		return nil, err
+	}
+	return t, tx.Commit()
+}
+
+// ReleaseLoginAttempt takes back an attempt ReserveLoginAttempt counted,
+// which turned out not to fail
+func (s *PostgresStore) ReleaseLoginAttempt(ctx context.Context, scope ThrottleScope, key string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE login_throttles SET failures = failures - 1 WHERE failures > 0 AND scope = $1 AND key = $2", scope, key)
+	return err
+}
+
+func (s *PostgresStore) ClearLoginThrottle(ctx context.Context, scope ThrottleScope, key string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM login_throttles WHERE "+recentFailure+" AND scope = $2 AND key = $3",
+		loginFailureWindow.Seconds(), scope, key)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrThrottleNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetTOTP(ctx context.Context, workerID string) (*TOTP, error) {
+	query := `SELECT worker_id, secret, confirmed_at, last_step, created_at,
+			(SELECT count(*) FROM totp_recovery_codes c WHERE c.worker_id = t.worker_id AND c.used_at IS NULL)
+		FROM worker_totp t WHERE worker_id = $1`
+
+	totp := new(TOTP)
+	err := s.db.QueryRowContext(ctx, query, workerID).Scan(&totp.WorkerID, &totp.Secret, &totp.ConfirmedAt, &totp.LastStep, &totp.CreatedAt, &totp.RecoveryCodesLeft)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return totp, nil
+}
+
+// SaveTOTP stores the pending secret of a worker, replacing any earlier
+// one, but never a confirmed secret
+func (s *PostgresStore) SaveTOTP(ctx context.Context, totp *TOTP) error {
+	query := `INSERT INTO worker_totp (worker_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (worker_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = now()
+			WHERE worker_totp.confirmed_at IS NULL
+		RETURNING created_at`
+
+	err := s.db.QueryRowContext(ctx, query, totp.WorkerID, totp.Secret).Scan(&totp.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTwoFactorEnabled
+	}
+	return dbError(err)
+}
+
+// ConfirmTOTP enables a worker's pending secret, step being the period of
+// the code that confirmed it, and stores their first recovery codes
+func (s *PostgresStore) ConfirmTOTP(ctx context.Context, workerID string, step int64, recoveryCodeHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE worker_totp SET confirmed_at = now(), last_step = $2
+		WHERE worker_id = $1 AND confirmed_at IS NULL`, workerID, step)
+	if err != nil {
+		return fmt.Errorf("failed to execute update query: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+	if err := insertRecoveryCodes(ctx, tx, workerID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code of step was accepted, refusing steps no
+// later than the last one so a code can't be replayed
+func (s *PostgresStore) UseTOTPStep(ctx context.Context, workerID string, step int64) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE worker_totp SET last_step = $2
+		WHERE worker_id = $1 AND confirmed_at IS NOT NULL AND last_step < $2`, workerID, step)
+	if err != nil {
+		return fmt.Errorf("failed to execute update query: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// DeleteTOTP turns off a worker's two-factor authentication, dropping
+// their recovery codes
+func (s *PostgresStore) DeleteTOTP(ctx context.Context, workerID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE worker_id = $1`, workerID); err != nil {
+		return dbError(err)
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM worker_totp WHERE worker_id = $1`, workerID)
+	if err != nil {
+		return dbError(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes swaps a worker's recovery codes for new ones
+func (s *PostgresStore) ReplaceRecoveryCodes(ctx context.Context, workerID string, hashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT true FROM worker_totp WHERE worker_id = $1 AND confirmed_at IS NOT NULL FOR UPDATE`, workerID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTwoFactorNotEnrolled
+	}
+	if err != nil {
+		return dbError(err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE worker_id = $1`, workerID); err != nil {
+		return dbError(err)
+	}
+	if err := insertRecoveryCodes(ctx, tx, workerID, hashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertRecoveryCodes(ctx context.Context, tx *sql.Tx, workerID string, hashes []string) error {
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO totp_recovery_codes (worker_id, code_hash) VALUES ($1, $2)`, workerID, hash); err != nil {
+			return dbError(err)
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode uses up the worker's unused recovery code with hash
+func (s *PostgresStore) UseRecoveryCode(ctx context.Context, workerID, hash string) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE totp_recovery_codes SET used_at = now()
+		WHERE worker_id = $1 AND code_hash = $2 AND used_at IS NULL`, workerID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to execute update query: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+func (s *PostgresStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	query := `INSERT INTO refresh_tokens (family_id, worker_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	return s.db.QueryRowContext(ctx, query, token.FamilyID, token.WorkerID, token.TokenHash, token.ExpiresAt).
+		Scan(&token.ID, &token.CreatedAt)
+}
+
+const refreshTokenColumns = `id, family_id, worker_id, token_hash, expires_at, created_at, used_at, revoked_at`
+
+func (s *PostgresStore) GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE token_hash = $1", hash)
+
+	token, err := scanIntoRefreshToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidRefreshToken
+	}
+	return token, err
+}
+
+// RotateRefreshToken marks the token with hash used and stores next in the
+// same family. Presenting a token that was already used means it leaked,
+// so the whole family is revoked and ErrRefreshTokenReused returned.
+func (s *PostgresStore) RotateRefreshToken(ctx context.Context, hash string, next *RefreshToken) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, "SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE", hash)
+	current, err := scanIntoRefreshToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+
+	if current.RevokedAt != nil {
+		return ErrInvalidRefreshToken
+	}
+	if current.UsedAt != nil {
+		if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = now()
+			WHERE family_id = $1 AND revoked_at IS NULL`, current.FamilyID); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrRefreshTokenReused
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return ErrInvalidRefreshToken
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = now() WHERE id = $1`, current.ID); err != nil {
+		return err
+	}
+
+	next.FamilyID = current.FamilyID
+	next.WorkerID = current.WorkerID
+	query := `INSERT INTO refresh_tokens (family_id, worker_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+	if err := tx.QueryRowContext(ctx, query, next.FamilyID, next.WorkerID, next.TokenHash, next.ExpiresAt).
+		Scan(&next.ID, &next.CreatedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) RevokeSession(ctx context.Context, familyID string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = now()
+		WHERE family_id = $1 AND revoked_at IS NULL`, familyID)
+	return err
+}
+
+func (s *PostgresStore) RevokeWorkerSessions(ctx context.Context, workerID string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = now()
+		WHERE worker_id = $1 AND revoked_at IS NULL`, workerID)
+	return err
+}
+
+// IsSessionActive reports whether the session still has a live refresh
+// token, i.e. it was neither revoked nor left to expire
+func (s *PostgresStore) IsSessionActive(ctx context.Context, familyID string) (bool, error) {
+	var active bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM refresh_tokens
+		WHERE family_id = $1 AND revoked_at IS NULL AND expires_at > now()
+	)`, familyID).Scan(&active)
+	return active, err
+}
+
+func scanIntoRefreshToken(row *sql.Row) (*RefreshToken, error) {
+	token := new(RefreshToken)
+	var usedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.WorkerID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&usedAt,
+		&revokedAt,
+	)
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return token, err
+}
+
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	query := `INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	return s.db.QueryRowContext(ctx,
+		query,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		pq.Array(scopeStrings(key.Scopes)),
+		sql.NullString{String: key.CreatedBy, Valid: key.CreatedBy != ""},
+	).Scan(&key.ID, &key.CreatedAt)
+}
+
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, coalesce(created_by::text, ''), created_at, last_used_at, revoked_at`
+
+func (s *PostgresStore) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = $1", prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, NotFound("api_key_not_found", "api key %s not found", prefix)
+	}
+	return scanIntoAPIKey(rows)
+}
+
+func (s *PostgresStore) GetAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanIntoAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return dbError(fmt.Errorf("failed to execute update query: %w", err))
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return NotFound("api_key_not_found", "no active api key found with ID %s", id)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) TouchAPIKey(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = now() WHERE id = $1`, id)
+	return err
+}
+
+func scanIntoAPIKey(rows *sql.Rows) (*APIKey, error) {
+	key := new(APIKey)
+	var scopes []string
+	var lastUsedAt, revokedAt sql.NullTime
+	err := rows.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		pq.Array(&scopes),
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, Scope(scope))
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+
+	return key, err
+}
+
+// sqlSet builds the SET clause of an UPDATE from the fields of a patch
+type sqlSet struct {
+	columns []string
+	args    []any
+}
+
+// setColumn sets column to *v, unless v is nil
+func setColumn[T any](s *sqlSet, column string, v *T) {
+	if v == nil {
+		return
+	}
+	s.args = append(s.args, *v)
+	s.columns = append(s.columns, fmt.Sprintf("%s = $%d", column, len(s.args)))
+}
+
+func (s *sqlSet) empty() bool {
+	return len(s.columns) == 0
+}
+
+func (s *sqlSet) String() string {
+	return " SET " + strings.Join(s.columns, ", ")
+}
+
+func scopeStrings(scopes []Scope) []string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return s
+}
+
+func scanIntoAccount(rows *sql.Rows) (*Command, error) {
+	command := new(Command)
+	var moveDate sql.NullTime
+	var quote []byte
+	err := rows.Scan(
+		&command.ID,
+		&command.FullName,
+		&command.Number,
+		&command.Flor,
+		&command.Elevator,
+		&command.Itemtype,
+		&command.Service,
+		&command.Workers,
+		&command.Start,
+		&command.Distination,
+		&moveDate,
+		&command.DurationMinutes,
+		&command.Prix.Amount,
+		&command.Prix.Currency,
+		&quote,
+		&command.Status,
+		&command.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if moveDate.Valid {
+		command.MoveDate = &moveDate.Time
+	}
+	if quote != nil {
+		command.Quote = new(Quote)
+		if err := json.Unmarshal(quote, command.Quote); err != nil {
+			return nil, fmt.Errorf("invalid quote of command %s: %w", command.ID, err)
+		}
+	}
+
+	return command, nil
+}
+
+// jsonColumn encodes v for a JSONB column, nil pointers as NULL. It's
+// passed as a string since lib/pq sends []byte as bytea.
+func jsonColumn[T any](v *T) (sql.NullString, error) {
+	if v == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+func scanIntoWorker(rows *sql.Rows) (*Worker, error) {
+	worker := new(Worker)
+	err := rows.Scan(
+		&worker.ID,
+		&worker.FullName,
+		&worker.Number,
+		&worker.Email,
+		&worker.Password,
+		&worker.Position,
+		&worker.Experience,
+		&worker.Message,
+		&worker.IsAccepted,
+		&worker.CreatedAt,
+		&worker.Role,
+		&worker.ApplicationStatus)
+
+	return worker, err
+}
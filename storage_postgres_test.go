@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestPostgresStore runs the storage tests against the database of
+// TEST_DATABASE_URL, migrating it first. Once each test is done, every
+// connection must be back in the pool: one still in use was leaked by rows
+// left open.
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	// A small pool, so that leaks also run it dry
+	store, err := NewPostgresStore(DatabaseConfig{DSN: dsn, MaxOpenConns: 2, MaxIdleConns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	testStorage(t, func(t *testing.T) Storage {
+		// Registered first, so it runs after the test's own cleanups
+		t.Cleanup(func() {
+			if inUse := store.db.Stats().InUse; inUse != 0 {
+				t.Errorf("%d connections still in use", inUse)
+			}
+		})
+		return store
+	})
+}
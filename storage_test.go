@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPassword passes validatePassword
+const testPassword = "Str0ng!Passw0rd"
+
+func TestMemoryStore(t *testing.T) {
+	testStorage(t, func(t *testing.T) Storage { return NewMemoryStore() })
+}
+
+// testStorage checks the behaviour handlers rely on from a Storage, so that
+// every implementation behaves alike. The stores newStore returns may
+// already hold data: each test only looks at what it created.
+func testStorage(t *testing.T, newStore func(t *testing.T) Storage) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, ctx context.Context, s Storage, uniq string)
+	}{
+		{"Commands", testStorageCommands},
+		{"CommandLifecycle", testStorageCommandLifecycle},
+		{"CommandPages", testStorageCommandPages},
+		{"Workers", testStorageWorkers},
+		{"EmailsIgnoreCase", testStorageEmailsIgnoreCase},
+		{"LoginAttempts", testStorageLoginAttempts},
+		{"TwoFactor", testStorageTwoFactor},
+		{"Assignments", testStorageAssignments},
+		{"AvailableWorkers", testStorageAvailableWorkers},
+		{"Applications", testStorageApplications},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uniq, err := newUUID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.run(t, context.Background(), newStore(t), uniq[:8])
+		})
+	}
+}
+
+func newTestCommand(t *testing.T, itemtype string, price int64) *Command {
+	t.Helper()
+	c, err := NewCommand("Karim Test", "0550123456", 2, true, itemtype, "moving", 2, "Alger", "Oran", nil, Money{Amount: price})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testStorageCommands(t *testing.T, ctx context.Context, s Storage, uniq string) {
+	c := newTestCommand(t, "sofa-"+uniq, 1500)
+	if err := s.CreateCommand(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID == "" || c.CreatedAt.IsZero() {
+		t.Fatalf("CreateCommand() left ID %q, CreatedAt %v unset", c.ID, c.CreatedAt)
+	}
+
+	got, err := s.GetCommandByID(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FullName != c.FullName || got.Itemtype != c.Itemtype || got.Prix != c.Prix || got.Status != StatusPending {
+		t.Errorf("GetCommandByID() = %+v, want %+v", got, c)
+	}
+
+	name := "Karim Patched"
+	patched, err := s.PatchCommand(ctx, c.ID, &CommandPatch{FullName: &name}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.FullName != name || patched.Itemtype != c.Itemtype {
+		t.Errorf("PatchCommand() = %+v, want only the name changed", patched)
+	}
+
+	if err := s.DeleteCommand(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetCommandByID(ctx, c.ID); KindOf(err) != KindNotFound {
+		t.Errorf("GetCommandByID() after delete error = %v, want not found", err)
+	}
+	if err := s.DeleteCommand(ctx, c); KindOf(err) != KindNotFound {
+		t.Errorf("DeleteCommand() twice error = %v, want not found", err)
+	}
+}
+
+func testStorageCommandLifecycle(t *testing.T, ctx context.Context, s Storage, uniq string) {
+	c := newTestCommand(t, "piano-"+uniq, 9000)
+	if err := s.CreateCommand(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.DeleteCommand(ctx, c) })
+
+	got, err := s.TransitionCommand(ctx, c.ID, StatusQuoted, "", "quoted by phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusQuoted {
+		t.Errorf("TransitionCommand() status = %s, want %s", got.Status, StatusQuoted)
+	}
+
+	if _, err := s.TransitionCommand(ctx, c.ID, StatusCompleted, "", ""); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("TransitionCommand() to completed error = %v, want ErrIllegalTransition", err)
+	}
+
+	history, err := s.GetCommandHistory(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].FromStatus != StatusPending || history[0].ToStatus != StatusQuoted || history[0].Note != "quoted by phone" {
+		t.Errorf("GetCommandHistory() = %+v, want the one transition to quoted", history)
+	}
+}
+
+func testStorageCommandPages(t *testing.T, ctx context.Context, s Storage, uniq string) {
+	itemtype := "box-" + uniq
+	for _, price := range []int64{500, 100, 400, 200, 300} {
+		c := newTestCommand(t, itemtype, price)
+		if err := s.CreateCommand(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.DeleteCommand(ctx, c) })
+	}
+
+	var prices []int64
+	q := &CommandQuery{Itemtype: itemtype, Sort: "price", Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("GetCommands() still has a next page after 3 pages of 2 out of 5")
+		}
+		page, err := s.GetCommands(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 5 {
+			t.Errorf("GetCommands() total = %d, want 5", page.Total)
+		}
+		for _, c := range page.Items {
+			prices = append(prices, c.Prix.Amount)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	want := []int64{100, 200, 300, 400, 500}
+	if len(prices) != len(want) {
+		t.Fatalf("GetCommands() pages hold prices %v, want %v", prices, want)
+	}
+	for i := range want {
+		if prices[i] != want[i] {
+			t.Fatalf("GetCommands() pages hold prices %v, want %v", prices, want)
+		}
+	}
+
+	q.Cursor = encodeCursor(pageCursor{Sort: "price", Key: "1 OR 1=1", ID: "not-a-uuid"})
+	if _, err := s.GetCommands(ctx, q); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("GetCommands() with a tampered cursor error = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func testStorageWorkers(t *testing.T, ctx context.Context, s Storage, uniq string) {
+	w := &Worker{
+		FullName: "Samir Test",
+		Number:   "0550123456",
+		Email:    "samir-" + uniq + "@krixo.test",
+		Password: testPassword,
+		Position: "mover-" + uniq,
+	}
+	if err := s.CreateWorker(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+	if w.ID == "" || w.Role != RoleWorker {
+		t.Fatalf("CreateWorker() left ID %q, role %q", w.ID, w.Role)
+	}
+
+	got, err := s.GetAccountByID(ctx, w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != w.Email || got.Password == "" || got.Password == testPassword {
+		t.Errorf("GetAccountByID() = email %q, password %q, want the email and a hash", got.Email, got.Password)
+	}
+
+	page, err := s.GetWorkers(ctx, &WorkerQuery{Position: w.Position})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Items) != 1 || page.Items[0].ID != w.ID {
+		t.Errorf("GetWorkers() = %+v, want the one worker of the position", page)
+	}
+
+	if _, err := s.Register(ctx, testPassword, w.Email); err != nil {
+		t.Errorf("Register() with the password error = %v", err)
+	}
+	if _, err := s.Register(ctx, "Wr0ng!Passw0rd", w.Email); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Register() with another password error = %v, want ErrInvalidCredentials", err)
+	}
+
+	name := "Samir Patched"
+	patched, err := s.PatchWorker(ctx, w.ID, &WorkerPatch{FullName: &name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.FullName != name || patched.Position != w.Position {
+		t.Errorf("PatchWorker() = %+v, want only the name changed", patched)
+	}
+
+	if err := s.DeleteWorker(ctx, w.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAccountByID(ctx, w.ID); KindOf(err) != KindNotFound {
+		t.Errorf("GetAccountByID() after delete error = %v, want not found", err)
+	}
+	if err := s.DeleteWorker(ctx, w.ID); KindOf(err) != KindNotFound {
+		t.Errorf("DeleteWorker() twice error = %v, want not found", err)
+	}
+}
+
+func testStorageEmailsIgnoreCase(t *testing.T, ctx context.Context, s Storage, uniq string) {
+	w := &Worker{FullName: "Nadia Test", Number: "0550123456", Email: " Nadia." + uniq + "@Krixo.TEST ", Password: testPassword, Position: "mover"}
+	if err := s.CreateWorker(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.DeleteWorker(ctx, w.ID) })
+
+	email := "nadia." + uniq + "@krixo.test"
+	if w.Email != email {
+		t.Errorf("CreateWorker() stored email %q, want %q", w.Email, email)
+	}
+
+	got, err := s.GetWorkerByEmail(ctx, "NADIA."+uniq+"@krixo.test")
+	if err != nil || got.ID != w.ID {
+		t.Errorf("GetWorkerByEmail() in upper case = %v, %v, want the worker", got, err)
+	}
+	if _, err := s.Register(ctx, testPassword, "Nadia."+uniq+"@krixo.test"); err != nil {
+		t.Errorf("Register() with the email in another case error = %v", err)
+	}
+
+	dup := &Worker{FullName: "Nadia Again", Number: "0550123456", Email: "NADIA." + uniq + "@krixo.test", Position: "mover"}
+	if err := s.CreateWorker(ctx, dup); KindOf(err) != KindConflict {
+		t.Errorf("CreateWorker() with the email in another case error = %v, want a conflict", err)
+		if err == nil {
+			s.DeleteWorker(ctx, dup.ID)
+		}
+	}
+}
+
+func testStorageLoginAttempts(t *testing.T, ctx context.Context, s Storage, uniq string) {
+	policy := loginPolicies[ThrottleAccount]
+	key := "attempts-" + uniq + "@krixo.test"
+	t.Cleanup(func() { s.ClearLoginThrottle(ctx, ThrottleAccount, key) })
+
+	for i := 1; i <= policy.freeAttempts+1; i++ {
+		throttle, err := s.ReserveLoginAttempt(ctx, ThrottleAccount, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if throttle.LockedUntil != nil || throttle.Failures != i {
+			t.Fatalf("ReserveLoginAttempt() %d = %d failures, locked until %v, want %d failures", i, throttle.Failures, throttle.LockedUntil, i)
+		}
+	}
+	throttle, err := s.ReserveLoginAttempt(ctx, ThrottleAccount, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if throttle.LockedUntil == nil || throttle.Failures != policy.freeAttempts+1 {
+		t.Errorf("ReserveLoginAttempt() past the free attempts = %d failures, locked until %v, want refused and not counted", throttle.Failures, throttle.LockedUntil)
+	}
+
+	if err := s.ReleaseLoginAttempt(ctx, ThrottleAccount, key); err != nil {
+		t.Fatal(err)
+	}
+	if throttle, err := s.GetLoginThrottle(ctx, ThrottleAccount, key); err != nil || throttle.Failures != policy.freeAttempts {
+		t.Errorf("GetLoginThrottle() after a release = %v, %v, want %d failures", throttle, err, policy.freeAttempts)
+	}
+
+	// Parallel attempts are counted one after the other
+	parallel := "parallel-" + uniq + "@krixo.test"
+	t.Cleanup(func() { s.ClearLoginThrottle(ctx, ThrottleAccount, parallel) })
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			throttle, err := s.ReserveLoginAttempt(ctx, ThrottleAccount, parallel)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if throttle.LockedUntil == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != policy.freeAttempts+1 {
+		t.Errorf("%d of 10 parallel attempts reserved, want %d", reserved, policy.freeAttempts+1)
+	}
+}
+
+func testStorageTwoFactor(t *testing.T, ctx context.Context, s Storage, uniq string) {
+	w := &Worker{FullName: "Yacine Test", Number: "0550123456", Email: "yacine-" + uniq + "@krixo.test", Password: testPassword, Position: "mover"}
+	if err := s.CreateWorker(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.DeleteWorker(ctx, w.ID) })
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveTOTP(ctx, &TOTP{WorkerID: w.ID, Secret: secret}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UseTOTPStep(ctx, w.ID, 100); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("UseTOTPStep() before confirming error = %v, want ErrInvalidTwoFactorCode", err)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ConfirmTOTP(ctx, w.ID, 100, hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	// A step is good once, and only after the last one used
+	for _, tt := range []struct {
+		step int64
+		ok   bool
+	}{{100, false}, {99, false}, {101, true}, {101, false}, {103, true}, {102, false}} {
+		err := s.UseTOTPStep(ctx, w.ID, tt.step)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("UseTOTPStep(%d) error = %v, want accepted %v", tt.step, err, tt.ok)
+		}
+	}
+	if totp, err := s.GetTOTP(ctx, w.ID); err != nil || totp.LastStep != 103 {
+		t.Errorf("GetTOTP() = %+v, %v, want last step 103", totp, err)
+	}
+
+	if err := s.UseRecoveryCode(ctx, w.ID, hashRecoveryCode(codes[0])); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UseRecoveryCode(ctx, w.ID, hashRecoveryCode(codes[0])); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("UseRecoveryCode() twice error = %v, want ErrInvalidTwoFactorCode", err)
+	}
+	if totp, err := s.GetTOTP(ctx, w.ID); err != nil || totp.RecoveryCodesLeft != recoveryCodeCount-1 {
+		t.Errorf("GetTOTP() = %+v, %v, want %d recovery codes left", totp, err, recoveryCodeCount-1)
+	}
+}
+
+// newTestCrewMember adds an accepted worker of position, removed once the
+// test is done
+func newTestCrewMember(t *testing.T, ctx context.Context, s Storage, uniq, name, position string) *Worker {
+	t.Helper()
+	w := &Worker{FullName: name, Number: "0550123456", Email: strings.ReplaceAll(strings.ToLower(name), " ", ".") + "-" + uniq + "@krixo.test", Password: testPassword, Position: position, IsAccepted: true}
+	if err := s.CreateWorker(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.DeleteWorker(ctx, w.ID) })
+	return w
+}
+
+// newTestJob adds an accepted command for workers on moveDate, removed
+// once the test is done
+func newTestJob(t *testing.T, ctx context.Context, s Storage, uniq string, moveDate time.Time, workers int) *Command {
+	t.Helper()
+	c := newTestCommand(t, "job-"+uniq, 1500)
+	c.MoveDate, c.Workers = &moveDate, workers
+	if err := s.CreateCommand(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.DeleteCommand(ctx, c) })
+	if _, err := s.TransitionCommand(ctx, c.ID, StatusAccepted, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// newTestTimeOff adds time off for the worker, approved if asked
+func newTestTimeOff(t *testing.T, ctx context.Context, s Storage, workerID string, start, end time.Time, approved bool) {
+	t.Helper()
+	timeOff := &TimeOff{WorkerID: workerID, Start: start, End: end, Reason: "test", Status: TimeOffPending}
+	if err := s.CreateTimeOff(ctx, timeOff); err != nil {
+		t.Fatal(err)
+	}
+	if approved {
+		if _, err := s.ReviewTimeOff(ctx, timeOff.ID, TimeOffApproved, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// monday is a Monday of the future at the time of day in Algiers
+func monday(hour, minute int) time.Time {
+	return time.Date(2031, time.March, 3, hour, minute, 0, 0, algiersTime)
+}
+
+func testStorageAssignments(t *testing.T, ctx context.Context, s Storage, uniq string) {
+	position := "crew-" + uniq
+	samir := newTestCrewMember(t, ctx, s, uniq, "Samir Crew", position)
+	nadia := newTestCrewMember(t, ctx, s, uniq, "Nadia Crew", position)
+	yacine := newTestCrewMember(t, ctx, s, uniq, "Yacine Crew", position)
+
+	// Jobs keep their crew busy 4 hours
+	morning := newTestJob(t, ctx, s, uniq, monday(9, 0), 1)
+	late := newTestJob(t, ctx, s, uniq, monday(11, 0), 1)
+	afternoon := newTestJob(t, ctx, s, uniq, monday(13, 0), 1)
+	tuesday := newTestJob(t, ctx, s, uniq, monday(9, 0).AddDate(0, 0, 1), 3)
+
+	assign := func(c *Command, w *Worker) error {
+		return s.AssignWorker(ctx, &Assignment{CommandID: c.ID, WorkerID: w.ID, Role: AssignmentMover})
+	}
+	if err := assign(morning, samir); err != nil {
+		t.Fatal(err)
+	}
+	if err := assign(late, samir); !errors.Is(err, ErrDoubleBooked) {
+		t.Errorf("AssignWorker() on an overlapping job error = %v, want ErrDoubleBooked", err)
+	}
+	if err := assign(afternoon, samir); err != nil {
+		t.Errorf("AssignWorker() on the job starting as the other ends error = %v", err)
+	}
+	if crew, err := s.GetAssignments(ctx, late.ID); err != nil || len(crew) != 0 {
+		t.Errorf("GetAssignments() of the refused job = %v, %v, want no crew", crew, err)
+	}
+
+	// Only approved time off keeps a worker off a job
+	newTestTimeOff(t, ctx, s, nadia.ID, monday(8, 0).AddDate(0, 0, 1), monday(12, 0).AddDate(0, 0, 1), true)
+	newTestTimeOff(t, ctx, s, yacine.ID, monday(8, 0).AddDate(0, 0, 1), monday(12, 0).AddDate(0, 0, 1), false)
+	if err := assign(tuesday, nadia); !errors.Is(err, ErrWorkerOnTimeOff) {
+		t.Errorf("AssignWorker() during approved time off error = %v, want ErrWorkerOnTimeOff", err)
+	}
+	if err := assign(tuesday, yacine); err != nil {
+		t.Errorf("AssignWorker() during pending time off error = %v", err)
+	}
+	if err := assign(tuesday, samir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rescheduling into a job of the crew changes nothing
+	moveDate := monday(10, 0)
+	if _, err := s.PatchCommand(ctx, tuesday.ID, &CommandPatch{MoveDate: &moveDate}, ""); !errors.Is(err, ErrDoubleBooked) {
+		t.Errorf("PatchCommand() moving the job over another error = %v, want ErrDoubleBooked", err)
+	}
+	if got, err := s.GetCommandByID(ctx, tuesday.ID); err != nil || !got.MoveDate.Equal(*tuesday.MoveDate) {
+		t.Errorf("GetCommandByID() after the refused move = %v, %v, want the move date unchanged", got, err)
+	}
+	duration := 300
+	if _, err := s.PatchCommand(ctx, morning.ID, &CommandPatch{DurationMinutes: &duration}, ""); !errors.Is(err, ErrDoubleBooked) {
+		t.Errorf("PatchCommand() lengthening the job over another error = %v, want ErrDoubleBooked", err)
+	}
+	moveDate = monday(9, 0).AddDate(0, 0, 2)
+	if _, err := s.PatchCommand(ctx, tuesday.ID, &CommandPatch{MoveDate: &moveDate}, ""); err != nil {
+		t.Errorf("PatchCommand() moving the job to a free day error = %v", err)
+	}
+
+	// Cancelled jobs free their crew
+	if _, err := s.TransitionCommand(ctx, morning.ID, StatusCancelled, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := assign(newTestJob(t, ctx, s, uniq, monday(8, 0), 1), samir); err != nil {
+		t.Errorf("AssignWorker() over a cancelled job error = %v", err)
+	}
+}
+
+func testStorageAvailableWorkers(t *testing.T, ctx context.Context, s Storage, uniq string) {
+	position := "available-" + uniq
+	weekdays := []AvailabilitySlot{{Weekday: "monday", Start: "08:00", End: "18:00"}}
+	crew := map[string]*Worker{}
+	for _, name := range []string{"Amine", "Bilal", "Chakib", "Djamel", "Elias"} {
+		w := newTestCrewMember(t, ctx, s, uniq, name+" Available", position)
+		if name != "Elias" {
+			if err := s.SetAvailability(ctx, w.ID, weekdays); err != nil {
+				t.Fatal(err)
+			}
+		}
+		crew[name] = w
+	}
+	applicant := &Worker{FullName: "Farid Applicant", Number: "0550123456", Email: "farid-" + uniq + "@krixo.test", Position: position}
+	if err := s.CreateWorker(ctx, applicant); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.DeleteWorker(ctx, applicant.ID) })
+	if err := s.SetAvailability(ctx, applicant.ID, weekdays); err != nil {
+		t.Fatal(err)
+	}
+
+	newTestTimeOff(t, ctx, s, crew["Bilal"].ID, monday(0, 0), monday(11, 0), true)
+	newTestTimeOff(t, ctx, s, crew["Djamel"].ID, monday(0, 0), monday(11, 0), false)
+	job := newTestJob(t, ctx, s, uniq, monday(7, 0), 1)
+	if err := s.AssignWorker(ctx, &Assignment{CommandID: job.ID, WorkerID: crew["Chakib"].ID, Role: AssignmentMover}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []string
+	}{
+		{"busy morning", monday(9, 0), monday(12, 0), []string{"Amine", "Djamel"}},
+		{"free afternoon", monday(14, 0), monday(17, 0), []string{"Amine", "Bilal", "Chakib", "Djamel"}},
+		{"just after the job", monday(11, 0), monday(12, 0), []string{"Amine", "Bilal", "Chakib", "Djamel"}},
+		{"past the slots", monday(17, 0), monday(19, 0), nil},
+		{"another day", monday(9, 0).AddDate(0, 0, 1), monday(12, 0).AddDate(0, 0, 1), nil},
+	}
+	for _, tt := range tests {
+		workers, err := s.GetAvailableWorkers(ctx, &AvailabilityQuery{From: tt.from, To: tt.to, Position: position})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, w := range workers {
+			got = append(got, strings.TrimSuffix(w.FullName, " Available"))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: GetAvailableWorkers() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func testStorageApplications(t *testing.T, ctx context.Context, s Storage, uniq string) {
+	w := &Worker{FullName: "Karim Applicant", Number: "0550123456", Email: "karim-" + uniq + "@krixo.test", Position: "mover"}
+	if err := s.CreateWorker(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.DeleteWorker(ctx, w.ID) })
+	if w.ApplicationStatus != ApplicationSubmitted || w.IsAccepted {
+		t.Fatalf("CreateWorker() = status %q, accepted %v, want a submitted application", w.ApplicationStatus, w.IsAccepted)
+	}
+
+	if _, err := s.TransitionApplication(ctx, w.ID, ApplicationHired, "", ""); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("TransitionApplication() skipping the interview error = %v, want ErrIllegalTransition", err)
+	}
+	if _, err := s.TransitionApplication(ctx, w.ID, "promoted", "", ""); !errors.Is(err, ErrInvalidApplicationStatus) {
+		t.Errorf("TransitionApplication() to an unknown status error = %v, want ErrInvalidApplicationStatus", err)
+	}
+	for _, to := range []ApplicationStatus{ApplicationScreening, ApplicationInterview} {
+		got, err := s.TransitionApplication(ctx, w.ID, to, "", "note "+string(to))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ApplicationStatus != to || got.IsAccepted {
+			t.Errorf("TransitionApplication(%s) = status %q, accepted %v", to, got.ApplicationStatus, got.IsAccepted)
+		}
+	}
+	hired, err := s.TransitionApplication(ctx, w.ID, ApplicationHired, "", "note hired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hired.ApplicationStatus != ApplicationHired || !hired.IsAccepted {
+		t.Errorf("TransitionApplication(hired) = status %q, accepted %v, want an accepted worker", hired.ApplicationStatus, hired.IsAccepted)
+	}
+	if _, err := s.TransitionApplication(ctx, w.ID, ApplicationRejected, "", ""); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("TransitionApplication() of a decided application error = %v, want ErrIllegalTransition", err)
+	}
+
+	history, err := s.GetApplicationHistory(ctx, w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, change := range history {
+		got = append(got, fmt.Sprintf("%s>%s %s", change.FromStatus, change.ToStatus, change.Note))
+	}
+	want := []string{"submitted>screening note screening", "screening>interview note interview", "interview>hired note hired"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetApplicationHistory() = %v, want %v", got, want)
+	}
+
+	missing, err := newUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.TransitionApplication(ctx, missing, ApplicationScreening, "", ""); KindOf(err) != KindNotFound {
+		t.Errorf("TransitionApplication() of a missing worker error = %v, want not found", err)
+	}
+}
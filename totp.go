@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// TOTP parameters, the defaults of authenticator apps (RFC 6238)
+const (
+	totpIssuer = "Krixo"
+	totpPeriod = 30 * time.Second
+	totpDigits = 6 // as formatted by totpCode
+	// totpSkew is how many periods before or after now a code is accepted
+	// in, for clocks drifting apart
+	totpSkew = 1
+)
+
+const (
+	recoveryCodeCount = 10
+	// twoFactorTokenTTL is how long a correct password waits for the code
+	twoFactorTokenTTL = 5 * time.Minute
+	// twoFactorPurpose marks two-factor tokens, so no other signed token
+	// can stand in for one
+	twoFactorPurpose = "2fa"
+)
+
+var (
+	ErrTwoFactorRequired    = Forbidden("two_factor_required", "two-factor authentication must be enabled for this account")
+	ErrTwoFactorNotEnrolled = NotFound("two_factor_not_enrolled", "two-factor authentication is not enabled")
+	ErrTwoFactorEnabled     = Conflict("two_factor_enabled", "two-factor authentication is already enabled")
+	ErrInvalidTwoFactorCode = Unauthorized("invalid_two_factor_code", "invalid authentication code")
+	ErrInvalidTwoFactor     = Unauthorized("invalid_two_factor_token", "invalid or expired two-factor login, log in again")
+)
+
+// mfaRequiredRoles are the roles that can't use the API, beyond enrolling,
+// without two-factor authentication. main sets it from the configuration.
+var mfaRequiredRoles = map[Role]bool{RoleAdmin: true}
+
+// mfaPolicy returns the set of the configured roles
+func mfaPolicy(config AuthConfig) map[Role]bool {
+	roles := map[Role]bool{}
+	for _, role := range config.MFARequiredRoles {
+		roles[role] = true
+	}
+	return roles
+}
+
+// TOTP is an account's authenticator secret. It is pending until a first
+// code confirms the worker saved it, and only then asked for at login.
+// LastStep is the period of the last code accepted, which can't be used
+// again.
+type TOTP struct {
+	WorkerID          string
+	Secret            string
+	ConfirmedAt       *time.Time
+	LastStep          int64
+	CreatedAt         time.Time
+	RecoveryCodesLeft int
+}
+
+// TwoFactorStatus is what an account sees of its two-factor setup
+type TwoFactorStatus struct {
+	Enabled           bool       `json:"enabled"`
+	Required          bool       `json:"required"`
+	ConfirmedAt       *time.Time `json:"confirmedat"`
+	RecoveryCodesLeft int        `json:"recoverycodesleft"`
+}
+
+// TwoFactorEnrollment is the secret to add to an authenticator app, as is
+// or by scanning OTPAuthURL as a QR code
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauthurl"`
+}
+
+// TwoFactorChallenge answers a correct password when the account has
+// two-factor authentication: the login finishes at /auth/2fa with Token
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"twofactorrequired"`
+	Token             string `json:"twofactortoken"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recoverycodes"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required,max=10"`
+}
+
+// TwoFactorLoginRequest finishes a login with a code from the app or, when
+// the device is lost, one of the recovery codes
+type TwoFactorLoginRequest struct {
+	Token        string `json:"twofactortoken" validate:"required,max=4096"`
+	Code         string `json:"code" validate:"max=10"`
+	RecoveryCode string `json:"recoverycode" validate:"max=30"`
+}
+
+// DisableTwoFactorRequest proves the account still holds a second factor
+type DisableTwoFactorRequest struct {
+	Code         string `json:"code" validate:"max=10"`
+	RecoveryCode string `json:"recoverycode" validate:"max=30"`
+}
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random 160-bit secret, the size RFC 4226
+// recommends, base32 encoded as authenticator apps expect
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// otpauthURL is the key URI authenticator apps import, usually as a QR code
+func otpauthURL(secret, account string) string {
+	label := url.PathEscape(totpIssuer + ":" + account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// totpStep is the period t falls in
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// totpCode computes the code of a step (RFC 4226 section 5.3)
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// checkTOTP returns the step code is valid in, around now, and false if it
+// matches none
+func checkTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := base32NoPadding.DecodeString(secret)
+	code = strings.TrimSpace(code)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCodes returns fresh recovery codes and their hashes. Each has
+// 80 random bits, too many to brute-force, so like tokens they are stored
+// with a fast hash.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := base32NoPadding.EncodeToString(b)
+		codes = append(codes, raw[0:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:16])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code however it was typed
+func hashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
+
+// twoFactorToken signs the token a correct password earns when the account
+// has two-factor authentication, good for finishing that login only
+func twoFactorToken(worker *Worker) (string, error) {
+	now := time.Now()
+	return jwtKeys.Sign(jwt.MapClaims{
+		"sub":     worker.ID,
+		"purpose": twoFactorPurpose,
+		"iat":     now.Unix(),
+		"exp":     now.Add(twoFactorTokenTTL).Unix(),
+	})
+}
+
+// parseTwoFactorToken checks a two-factor token's signature, expiry and
+// purpose, returning the worker it names
+func parseTwoFactorToken(token string) (string, error) {
+	parsed, err := validateJWT(token)
+	if err != nil || !parsed.Valid {
+		return "", ErrInvalidTwoFactor.withCause(err)
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != twoFactorPurpose {
+		return "", ErrInvalidTwoFactor
+	}
+	workerID, _ := claims["sub"].(string)
+	if workerID == "" {
+		return "", ErrInvalidTwoFactor
+	}
+	return workerID, nil
+}
+
+// twoFactorEnabled reports whether worker has confirmed an authenticator
+func (s *APIServer) twoFactorEnabled(ctx context.Context, workerID string) (bool, error) {
+	totp, err := s.store.GetTOTP(ctx, workerID)
+	if KindOf(err) == KindNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return totp.ConfirmedAt != nil, nil
+}
+
+// verifySecondFactor checks a code from the worker's app, or else one of
+// their recovery codes, using it up
+func (s *APIServer) verifySecondFactor(ctx context.Context, workerID, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		return s.store.UseRecoveryCode(ctx, workerID, hashRecoveryCode(recoveryCode))
+	}
+
+	totp, err := s.store.GetTOTP(ctx, workerID)
+	if KindOf(err) == KindNotFound {
+		return ErrInvalidTwoFactorCode
+	}
+	if err != nil {
+		return err
+	}
+	step, ok := checkTOTP(totp.Secret, code, time.Now())
+	if !ok || totp.ConfirmedAt == nil {
+		return ErrInvalidTwoFactorCode
+	}
+	return s.store.UseTOTPStep(ctx, workerID, step)
+}
+
+// withTwoFactorPolicy marks requests from accounts whose role requires
+// two-factor authentication they haven't enabled, for withAccess to refuse
+// them. It must be wrapped by withJWTAuth.
+func withTwoFactorPolicy(ctx context.Context, s Storage, account *Worker) (context.Context, error) {
+	if !mfaRequiredRoles[account.Role] {
+		return ctx, nil
+	}
+
+	totp, err := s.GetTOTP(ctx, account.ID)
+	if err != nil && KindOf(err) != KindNotFound {
+		return ctx, err
+	}
+	if err != nil || totp.ConfirmedAt == nil {
+		ctx = context.WithValue(ctx, "twoFactorPending", true)
+	}
+	return ctx, nil
+}
+
+// twoFactorPending reports whether the caller must enable two-factor
+// authentication before using anything but its setup
+func twoFactorPending(r *http.Request) bool {
+	pending, _ := r.Context().Value("twoFactorPending").(bool)
+	return pending
+}
+
+// twoFactorSetup serves f to any logged-in account, including those the
+// policy keeps out of everything else until they enable two-factor
+// authentication. API keys are refused.
+func (s *APIServer) twoFactorSetup(f apiFunc) http.HandlerFunc {
+	h := makeHTTPHandleFunc(f)
+	return corsMiddleware(withJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		if currentUserID(r) == "" {
+			permissionDenied(w, r)
+			return
+		}
+		h(w, r)
+	}, s.store))
+}
+
+func (s *APIServer) handleGetTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	status := &TwoFactorStatus{Required: mfaRequiredRoles[currentRole(r)]}
+
+	totp, err := s.store.GetTOTP(r.Context(), currentUserID(r))
+	if err != nil && KindOf(err) != KindNotFound {
+		return err
+	}
+	if err == nil && totp.ConfirmedAt != nil {
+		status.Enabled = true
+		status.ConfirmedAt = totp.ConfirmedAt
+		status.RecoveryCodesLeft = totp.RecoveryCodesLeft
+	}
+
+	return WriteJSON(w, http.StatusOK, status)
+}
+
+// handleEnrollTwoFactor starts enrolling an authenticator, replacing any
+// pending secret. Codes are only asked for once it's confirmed.
+func (s *APIServer) handleEnrollTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	worker, err := s.store.GetAccountByID(r.Context(), currentUserID(r))
+	if err != nil {
+		return err
+	}
+	enabled, err := s.twoFactorEnabled(r.Context(), worker.ID)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		return ErrTwoFactorEnabled
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return err
+	}
+	if err := s.store.SaveTOTP(r.Context(), &TOTP{WorkerID: worker.ID, Secret: secret}); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusCreated, &TwoFactorEnrollment{
+		Secret:     secret,
+		OTPAuthURL: otpauthURL(secret, worker.Email),
+	})
+}
+
+// handleConfirmTwoFactor enables two-factor authentication once a code
+// shows the app holds the pending secret, returning the recovery codes,
+// shown this once. Every session of the account is revoked, so all of
+// them have passed the second factor.
+func (s *APIServer) handleConfirmTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	req := new(TwoFactorCodeRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	workerID := currentUserID(r)
+	totp, err := s.store.GetTOTP(r.Context(), workerID)
+	if err != nil {
+		return err
+	}
+	if totp.ConfirmedAt != nil {
+		return ErrTwoFactorEnabled
+	}
+	step, ok := checkTOTP(totp.Secret, req.Code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	if err := s.store.ConfirmTOTP(r.Context(), workerID, step, hashes); err != nil {
+		return err
+	}
+	if err := s.store.RevokeWorkerSessions(r.Context(), workerID); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, &RecoveryCodes{Codes: codes})
+}
+
+// handleRegenerateRecoveryCodes replaces the account's recovery codes, the
+// old ones no longer working
+func (s *APIServer) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) error {
+	req := new(TwoFactorCodeRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	workerID := currentUserID(r)
+	if err := s.verifySecondFactor(r.Context(), workerID, req.Code, ""); err != nil {
+		return err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	if err := s.store.ReplaceRecoveryCodes(r.Context(), workerID, hashes); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, &RecoveryCodes{Codes: codes})
+}
+
+// handleDisableTwoFactor turns two-factor authentication off, given a code
+// or recovery code
+func (s *APIServer) handleDisableTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	req := new(DisableTwoFactorRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	workerID := currentUserID(r)
+	enabled, err := s.twoFactorEnabled(r.Context(), workerID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTwoFactorNotEnrolled
+	}
+	if err := s.verifySecondFactor(r.Context(), workerID, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+	if err := s.store.DeleteTOTP(r.Context(), workerID); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleResetTwoFactor turns off the two-factor authentication of a worker
+// who lost both their device and recovery codes, logging them out
+func (s *APIServer) handleResetTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.DeleteTOTP(r.Context(), id); err != nil {
+		return err
+	}
+	if err := s.store.RevokeWorkerSessions(r.Context(), id); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleTwoFactorLogin finishes a login the password step answered with a
+// TwoFactorChallenge. Wrong codes count as failed logins of the account.
+func (s *APIServer) handleTwoFactorLogin(w http.ResponseWriter, r *http.Request) error {
+	req := new(TwoFactorLoginRequest)
+	if err := decodeJSON(r, req); err != nil {
+		return err
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+
+	workerID, err := parseTwoFactorToken(req.Token)
+	if err != nil {
+		return err
+	}
+	worker, err := s.store.GetAccountByID(r.Context(), workerID)
+	if KindOf(err) == KindNotFound {
+		return ErrInvalidTwoFactor
+	}
+	if err != nil {
+		return err
+	}
+	if !worker.IsAccepted {
+		return ErrAccountInactive
+	}
+
+	attempt, err := s.reserveLoginAttempt(r.Context(), w, s.loginKeys(r, worker.Email))
+	if err != nil {
+		return err
+	}
+	err = s.verifySecondFactor(r.Context(), worker.ID, req.Code, req.RecoveryCode)
+	if KindOf(err) == KindUnauthorized {
+		s.loginFailed(attempt)
+		return err
+	}
+	if err != nil {
+		s.releaseLoginAttempt(r.Context(), attempt)
+		return err
+	}
+	s.loginSucceeded(r.Context(), attempt)
+
+	if _, err := s.startSession(r.Context(), w, worker); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, newWorkerSelf(worker))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the key of the test vectors of RFC 4226 and RFC 6238
+var rfcSecret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 4226 Appendix D, the HOTP values of counters 0 to 9
+	hotp := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, want := range hotp {
+		if got := totpCode(rfcSecret, int64(counter)); got != want {
+			t.Errorf("totpCode(counter %d) = %s, want %s", counter, got, want)
+		}
+	}
+
+	// RFC 6238 Appendix B, SHA-1, of which 6 digits are the last 6 of the
+	// 8 there
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(rfcSecret, totpStep(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("totpCode(at %d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	secret := base32NoPadding.EncodeToString(rfcSecret)
+	at := func(unix int64) time.Time { return time.Unix(unix, 0) }
+
+	tests := []struct {
+		name string
+		code string
+		now  time.Time
+		step int64
+		ok   bool
+	}{
+		{"current period", "287082", at(59), 1, true},
+		{"spaces around", " 287082 ", at(59), 1, true},
+		{"previous period", "287082", at(89), 1, true},
+		{"next period", "287082", at(29), 1, true},
+		{"two periods late", "287082", at(119), 0, false},
+		{"another code", "287083", at(59), 0, false},
+		{"8 digits", "94287082", at(59), 0, false},
+		{"empty", "", at(59), 0, false},
+	}
+	for _, tt := range tests {
+		step, ok := checkTOTP(secret, tt.code, tt.now)
+		if step != tt.step || ok != tt.ok {
+			t.Errorf("%s: checkTOTP() = %d, %v, want %d, %v", tt.name, step, ok, tt.step, tt.ok)
+		}
+	}
+
+	if _, ok := checkTOTP("not base32!", "287082", at(59)); ok {
+		t.Error("checkTOTP() accepted a code for an invalid secret")
+	}
+}
+
+// twoFactorLogin logs w in with its password, then finishes the login with
+// the second factor of req, returning the response
+func (a *workerAPI) twoFactorLogin(w *Worker, req *TwoFactorLoginRequest) (*http.Response, string) {
+	a.t.Helper()
+	_, body := a.do(http.MethodPost, "/Regestration", nil, &LoginRequest{Email: w.Email, Password: testPassword})
+	challenge := new(TwoFactorChallenge)
+	if err := json.Unmarshal([]byte(body), challenge); err != nil || !challenge.TwoFactorRequired {
+		a.t.Fatalf("login of %s = %s, want a two-factor challenge", w.Email, body)
+	}
+	req.Token = challenge.Token
+	return a.do(http.MethodPost, "/auth/2fa", nil, req)
+}
+
+func TestTOTPCodesAreUsedOnce(t *testing.T) {
+	a := newWorkerAPI(t)
+	w := a.createAccount("totp@krixo.test", RoleWorker)
+	a.enableTwoFactor(w)
+	totp, err := a.store.GetTOTP(context.Background(), w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := base32NoPadding.DecodeString(totp.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Enabling used up the current period, leaving the next within skew
+	current := totp.LastStep
+	if resp, body := a.twoFactorLogin(w, &TwoFactorLoginRequest{Code: totpCode(key, current)}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("login with the code that enabled 2FA = %d %s, want 401", resp.StatusCode, body)
+	}
+	next := totpCode(key, current+1)
+	if resp, body := a.twoFactorLogin(w, &TwoFactorLoginRequest{Code: next}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("login with the next code = %d %s, want 200", resp.StatusCode, body)
+	}
+	if resp, body := a.twoFactorLogin(w, &TwoFactorLoginRequest{Code: next}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("login with the same code again = %d %s, want 401", resp.StatusCode, body)
+	}
+}
+
+func TestRecoveryCodesAreUsedOnce(t *testing.T) {
+	a := newWorkerAPI(t)
+	w := a.createAccount("recovery@krixo.test", RoleWorker)
+	code := a.enableTwoFactor(w)
+
+	if resp, body := a.twoFactorLogin(w, &TwoFactorLoginRequest{RecoveryCode: code}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("login with a recovery code = %d %s, want 200", resp.StatusCode, body)
+	}
+	if resp, body := a.twoFactorLogin(w, &TwoFactorLoginRequest{RecoveryCode: code}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("login with the same recovery code again = %d %s, want 401", resp.StatusCode, body)
+	}
+}
@@ -1,76 +1,148 @@
 package main
 
+import "time"
+
 type LoginResponse struct {
-	ID    string `json:"id"`
-	Token string `json:"token"`
+	ID           string `json:"id"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshtoken,omitempty"`
 }
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required,max=100"`
+	Password string `json:"password" validate:"required,max=100"`
+}
+
+// DefaultCurrency is the ISO 4217 code used when a price has none
+const DefaultCurrency = "DZD"
+
+// Money is an amount in minor units (e.g. centimes) of Currency
+type Money struct {
+	Amount   int64  `json:"amount" validate:"min=0"`
+	Currency string `json:"currency" validate:"currency"`
 }
 
+// CreateCommandRequest carries no price: the command is priced with a
+// quote from the current rate card
 type CreateCommandRequest struct {
-	FullName    string `json:"fullname"`
-	Number      string `json:"number"`
-	Flor        string `json:"flor"`
-	Itemtype    string `json:"itemtype"`
-	Service     string `json:"service"`
-	Workers     string `json:"workers"`
-	Start       string `json:"start"`
-	Distination string `json:"distination"`
-	Prix        string `json:"prise"`
-	IsAccepted  string `json:"isaccepted"`
+	FullName string `json:"fullname" validate:"required,max=100"`
+	Number   string `json:"number" validate:"required,max=100,phone"`
+	QuoteRequest
 }
 
 type Command struct {
-	ID          string `json:"id"`
-	FullName    string `json:"fullname"`
-	Number      string `json:"number"`
-	Flor        string `json:"flor"`
-	Itemtype    string `json:"itemtype"`
-	Service     string `json:"service"`
-	Workers     string `json:"workers"`
-	Start       string `json:"start"`
-	Distination string `json:"distination"`
-	Prix        string `json:"prise"`
-	IsAccepted  string `json:"isaccepted"`
+	ID              string        `json:"id"`
+	FullName        string        `json:"fullname"`
+	Number          string        `json:"number"`
+	Flor            int           `json:"flor"`
+	Elevator        bool          `json:"elevator"`
+	Itemtype        string        `json:"itemtype"`
+	Service         string        `json:"service"`
+	Workers         int           `json:"workers"`
+	Start           string        `json:"start"`
+	Distination     string        `json:"distination"`
+	MoveDate        *time.Time    `json:"movedate"`
+	DurationMinutes int           `json:"durationminutes"`
+	Prix            Money         `json:"prise"`
+	Quote           *Quote        `json:"quote"`
+	Status          CommandStatus `json:"status"`
+	CreatedAt       time.Time     `json:"createdat"`
 }
 
-func NewCommand(fullname, number, flor, itemtype, service, workers, start, distination, prix, isaccepted string) (*Command, error) {
+func NewCommand(fullname, number string, flor int, elevator bool, itemtype, service string, workers int, start, distination string, moveDate *time.Time, prix Money) (*Command, error) {
+	if prix.Currency == "" {
+		prix.Currency = DefaultCurrency
+	}
 	return &Command{
-		FullName:    fullname,
-		Number:      number,
-		Flor:        flor,
-		Itemtype:    itemtype,
-		Service:     service,
-		Workers:     workers,
-		Start:       start,
-		Distination: distination,
-		Prix:        prix,
-		IsAccepted:  isaccepted,
+		FullName:        fullname,
+		Number:          number,
+		Flor:            flor,
+		Elevator:        elevator,
+		Itemtype:        itemtype,
+		Service:         service,
+		Workers:         workers,
+		Start:           start,
+		Distination:     distination,
+		MoveDate:        moveDate,
+		Prix:            prix,
+		Status:          StatusPending,
+		DurationMinutes: int(defaultJobDuration / time.Minute),
 	}, nil
 }
 
+type TransitionCommandRequest struct {
+	Status CommandStatus `json:"status" validate:"required,oneof=pending|quoted|accepted|scheduled|in_progress|completed|cancelled|rejected"`
+	Note   string        `json:"note" validate:"max=1000"`
+}
+
+func commandID(c *Command) string { return c.ID }
+
+// CommandStatusChange is one entry of a command's status history
+type CommandStatusChange struct {
+	ID         string        `json:"id"`
+	CommandID  string        `json:"commandid"`
+	FromStatus CommandStatus `json:"fromstatus"`
+	ToStatus   CommandStatus `json:"tostatus"`
+	ChangedBy  string        `json:"changedby"`
+	Note       string        `json:"note"`
+	ChangedAt  time.Time     `json:"changedat"`
+}
+
+func workerID(w *Worker) string { return w.ID }
+
 type CreateWorkerRequest struct {
-	FullName   string `json:"fullname"`
-	Number     string `json:"number"`
-	Email      string `json:"email"`
-	Password   string `json:"password"`
-	Position   string `json:"position"`
-	Experience string `json:"experience"`
-	Message    string `json:"message"`
-	IsAccepted bool   `json:"isaccepted"`
+	FullName   string `json:"fullname" validate:"required,max=100"`
+	Number     string `json:"number" validate:"required,max=20,phone"`
+	Email      string `json:"email" validate:"required,max=100,email"`
+	Position   string `json:"position" validate:"required,max=100"`
+	Experience string `json:"experience" validate:"max=5000"`
+	Message    string `json:"message" validate:"max=5000"`
 }
 
 type Worker struct {
-	ID         string `json:"id"`
-	FullName   string `json:"fullname"`
-	Number     string `json:"number"`
-	Email      string `json:"email"`
-	Password   string `json:"password"`
-	Position   string `json:"position"`
-	Experience string `json:"experience"`
-	Message    string `json:"message"`
-	IsAccepted bool   `json:"isaccepted"`
+	ID         string    `json:"id"`
+	FullName   string    `json:"fullname"`
+	Number     string    `json:"number"`
+	Email      string    `json:"email"`
+	Password   string    `json:"-"`
+	Position   string    `json:"position"`
+	Experience string    `json:"experience"`
+	Message    string    `json:"message"`
+	IsAccepted bool      `json:"isaccepted"`
+	CreatedAt  time.Time `json:"createdat"`
+	Role       Role      `json:"role"`
+	// ApplicationStatus is where the worker's application is in the
+	// hiring pipeline; only hired workers can be accepted
+	ApplicationStatus ApplicationStatus `json:"applicationstatus"`
+}
+
+// CommandPatch is a partial update of a command: only the fields present
+// in the request are changed. A new Status goes through the lifecycle
+// rules, recorded in the history with Note.
+type CommandPatch struct {
+	FullName        *string        `json:"fullname" validate:"notempty,max=100"`
+	Number          *string        `json:"number" validate:"notempty,max=100,phone"`
+	Flor            *int           `json:"flor" validate:"min=-5,max=200"`
+	Elevator        *bool          `json:"elevator"`
+	Itemtype        *string        `json:"itemtype" validate:"notempty,max=100"`
+	Service         *string        `json:"service" validate:"notempty,max=100"`
+	Workers         *int           `json:"workers" validate:"min=1,max=50"`
+	Start           *string        `json:"start" validate:"notempty,max=100"`
+	Distination     *string        `json:"distination" validate:"notempty,max=100"`
+	MoveDate        *time.Time     `json:"movedate" validate:"future"`
+	DurationMinutes *int           `json:"durationminutes" validate:"min=30,max=1440"`
+	Prix            *Money         `json:"prise"`
+	Status          *CommandStatus `json:"status" validate:"oneof=pending|quoted|accepted|scheduled|in_progress|completed|cancelled|rejected"`
+	Note            string         `json:"note" validate:"max=1000"`
+}
+
+// WorkerPatch is a partial update of a worker, changing only the fields
+// present in the request
+type WorkerPatch struct {
+	FullName   *string `json:"fullname" validate:"notempty,max=100"`
+	Number     *string `json:"number" validate:"notempty,max=20,phone"`
+	Position   *string `json:"position" validate:"notempty,max=100"`
+	Experience *string `json:"experience" validate:"max=5000"`
+	Message    *string `json:"message" validate:"max=5000"`
+	IsAccepted *bool   `json:"isaccepted"`
 }
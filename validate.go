@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Field error codes returned to clients, stable so frontends can map them
+// to their own messages
+const (
+	CodeRequired          = "required"
+	CodeTooLong           = "too_long"
+	CodeTooShort          = "too_short"
+	CodeTooSmall          = "too_small"
+	CodeTooLarge          = "too_large"
+	CodeInvalidPhone      = "invalid_phone"
+	CodeInvalidEmail      = "invalid_email"
+	CodeInvalidCurrency   = "invalid_currency"
+	CodeInvalidValue      = "invalid_value"
+	CodeNotInFuture       = "not_in_future"
+	CodeWeakPassword      = "weak_password"
+	CodeInvalidCharacters = "invalid_characters"
+)
+
+// FieldError is one failed rule, Field being the json path of the value
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field of a request that failed validation
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(field, code, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Validate checks a request struct against the rules in its `validate`
+// tags, returning a *ValidationError listing every failure. Rules are
+// comma separated:
+//
+//	required   non-zero value (non-empty for strings, slices and maps, non-nil pointer)
+//	notempty   non-zero value when present, for the optional fields of patches
+//	max=N      at most N characters for strings, at most N for numbers
+//	min=N      at least N characters for strings, at least N for numbers
+//	phone      a phone number of 8 to 15 digits, optionally +-prefixed
+//	email      an email address
+//	currency   an ISO 4217 code such as DZD
+//	future     a time after now
+//	oneof=a|b  one of the listed values
+//	password   the strength rules of validatePassword
+//
+// Nested structs, or non-nil pointers to them, without a tag are validated
+// with their json path as prefix; embedded structs without a prefix.
+func Validate(v any) error {
+	verr := new(ValidationError)
+	validateStruct(reflect.Indirect(reflect.ValueOf(v)), "", verr)
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func validateStruct(v reflect.Value, prefix string, verr *ValidationError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		// Embedded structs' fields are the outer struct's, as in json
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+			validateStruct(v.Field(i), prefix, verr)
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		value := v.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			if value.Kind() == reflect.Pointer && !value.IsNil() {
+				value = value.Elem()
+			}
+			if value.Kind() == reflect.Struct && value.Type() != reflect.TypeOf(time.Time{}) {
+				validateStruct(value, name, verr)
+			}
+			continue
+		}
+
+		for _, rule := range strings.Split(tag, ",") {
+			if !validateRule(value, name, rule, verr) {
+				// Report one failure per field, the first rule broken
+				break
+			}
+		}
+	}
+}
+
+var (
+	phoneSeparators = strings.NewReplacer(" ", "", ".", "", "-", "", "(", "", ")", "")
+	phoneRegex      = regexp.MustCompile(`^\+?[0-9]{8,15}$`)
+	currencyRegex   = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// validateRule applies one rule, reporting whether the value passed
+func validateRule(value reflect.Value, field, rule string, verr *ValidationError) bool {
+	name, arg, _ := strings.Cut(rule, "=")
+
+	if name == "required" {
+		if value.IsZero() || ((value.Kind() == reflect.Slice || value.Kind() == reflect.Map) && value.Len() == 0) {
+			verr.add(field, CodeRequired, "is required")
+			return false
+		}
+		return true
+	}
+
+	// Other rules don't apply to absent optional values
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return true
+		}
+		value = value.Elem()
+	}
+
+	if name == "notempty" {
+		if value.IsZero() {
+			verr.add(field, CodeRequired, "must not be empty")
+			return false
+		}
+		return true
+	}
+	if value.Kind() == reflect.String && value.String() == "" {
+		return true
+	}
+
+	switch name {
+	case "max", "min":
+		limit, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validate: invalid %s rule on %s", rule, field))
+		}
+		return validateBound(value, field, name, limit, verr)
+
+	case "phone":
+		if !phoneRegex.MatchString(phoneSeparators.Replace(value.String())) {
+			verr.add(field, CodeInvalidPhone, "must be a phone number of 8 to 15 digits")
+			return false
+		}
+
+	case "email":
+		if !isValidEmail(normalizeEmail(value.String())) {
+			verr.add(field, CodeInvalidEmail, "must be a valid email address")
+			return false
+		}
+
+	case "currency":
+		if !currencyRegex.MatchString(value.String()) {
+			verr.add(field, CodeInvalidCurrency, "must be an ISO 4217 currency code")
+			return false
+		}
+
+	case "future":
+		t, ok := value.Interface().(time.Time)
+		if ok && !t.After(time.Now()) {
+			verr.add(field, CodeNotInFuture, "must be in the future")
+			return false
+		}
+
+	case "oneof":
+		s := fmt.Sprint(value.Interface())
+		for _, allowed := range strings.Split(arg, "|") {
+			if s == allowed {
+				return true
+			}
+		}
+		verr.add(field, CodeInvalidValue, "must be one of "+strings.ReplaceAll(arg, "|", ", "))
+		return false
+
+	case "password":
+		if err := validatePassword(strings.TrimSpace(value.String()), DefaultConfig); err != nil {
+			verr.add(field, passwordErrorCode(err), err.Error())
+			return false
+		}
+
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q on %s", rule, field))
+	}
+
+	return true
+}
+
+func validateBound(value reflect.Value, field, name string, limit int64, verr *ValidationError) bool {
+	switch value.Kind() {
+	case reflect.String:
+		n := int64(utf8.RuneCountInString(value.String()))
+		if name == "max" && n > limit {
+			verr.add(field, CodeTooLong, fmt.Sprintf("must be at most %d characters", limit))
+			return false
+		}
+		if name == "min" && n < limit {
+			verr.add(field, CodeTooShort, fmt.Sprintf("must be at least %d characters", limit))
+			return false
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := value.Int()
+		if name == "max" && n > limit {
+			verr.add(field, CodeTooLarge, fmt.Sprintf("must be at most %d", limit))
+			return false
+		}
+		if name == "min" && n < limit {
+			verr.add(field, CodeTooSmall, fmt.Sprintf("must be at least %d", limit))
+			return false
+		}
+
+	case reflect.Slice:
+		n := int64(value.Len())
+		if name == "max" && n > limit {
+			verr.add(field, CodeTooLarge, fmt.Sprintf("must have at most %d items", limit))
+			return false
+		}
+		if name == "min" && n < limit {
+			verr.add(field, CodeTooSmall, fmt.Sprintf("must have at least %d items", limit))
+			return false
+		}
+
+	default:
+		panic(fmt.Sprintf("validate: %s rule on unsupported %s field %s", name, value.Kind(), field))
+	}
+
+	return true
+}
+
+func passwordErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrPasswordTooShort):
+		return CodeTooShort
+	case errors.Is(err, ErrPasswordTooLong):
+		return CodeTooLong
+	case errors.Is(err, ErrInvalidCharacters):
+		return CodeInvalidCharacters
+	default:
+		return CodeWeakPassword
+	}
+}
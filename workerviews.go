@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Worker is never written to clients as is: handlers go through the views
+// below, which pick the fields the caller may see. Credentials are in
+// none of them.
+
+// WorkerPublic is what anyone allowed to see a worker sees, e.g. another
+// worker of their crew
+type WorkerPublic struct {
+	ID       string `json:"id"`
+	FullName string `json:"fullname"`
+	Position string `json:"position"`
+	Role     Role   `json:"role"`
+}
+
+// WorkerSelf is an account as its owner sees it. Dispatchers and API keys
+// see other workers this way too, to reach the crews they plan.
+type WorkerSelf struct {
+	WorkerPublic
+	Number     string    `json:"number"`
+	Email      string    `json:"email"`
+	Experience string    `json:"experience"`
+	IsAccepted bool      `json:"isaccepted"`
+	CreatedAt  time.Time `json:"createdat"`
+}
+
+// WorkerAdminView adds the application under review, for admins
+type WorkerAdminView struct {
+	WorkerSelf
+	Message           string            `json:"message"`
+	ApplicationStatus ApplicationStatus `json:"applicationstatus"`
+}
+
+func newWorkerPublic(w *Worker) WorkerPublic {
+	return WorkerPublic{ID: w.ID, FullName: w.FullName, Position: w.Position, Role: w.Role}
+}
+
+func newWorkerSelf(w *Worker) *WorkerSelf {
+	return &WorkerSelf{
+		WorkerPublic: newWorkerPublic(w),
+		Number:       w.Number,
+		Email:        w.Email,
+		Experience:   w.Experience,
+		IsAccepted:   w.IsAccepted,
+		CreatedAt:    w.CreatedAt,
+	}
+}
+
+func newWorkerAdminView(w *Worker) *WorkerAdminView {
+	return &WorkerAdminView{
+		WorkerSelf:        *newWorkerSelf(w),
+		Message:           w.Message,
+		ApplicationStatus: w.ApplicationStatus,
+	}
+}
+
+// workerView returns the view of worker the caller of r may see
+func workerView(r *http.Request, worker *Worker) any {
+	switch {
+	case currentAPIKey(r) != nil:
+		return newWorkerSelf(worker)
+	case currentRole(r) == RoleAdmin:
+		return newWorkerAdminView(worker)
+	case currentRole(r) == RoleDispatcher, currentUserID(r) == worker.ID:
+		return newWorkerSelf(worker)
+	default:
+		p := newWorkerPublic(worker)
+		return &p
+	}
+}
+
+// workerViews returns the views of workers the caller of r may see
+func workerViews(r *http.Request, workers []*Worker) []any {
+	views := make([]any, len(workers))
+	for i, worker := range workers {
+		views[i] = workerView(r, worker)
+	}
+	return views
+}
+
+// workerPageView is page with the views of its workers the caller of r
+// may see
+func workerPageView(r *http.Request, page *Page[*Worker]) *Page[any] {
+	return &Page[any]{Items: workerViews(r, page.Items), NextCursor: page.NextCursor, Total: page.Total}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// workerAPI is a server on a MemoryStore with a few accounts logged in
+type workerAPI struct {
+	t      *testing.T
+	server *httptest.Server
+	store  *MemoryStore
+	// secrets are strings no response body may hold: password hashes,
+	// TOTP secrets, recovery codes, session tokens and their hashes
+	secrets []string
+}
+
+func newWorkerAPI(t *testing.T) *workerAPI {
+	t.Setenv("JWT_SECRET", "0123456789abcdef0123456789abcdef0123456789")
+	keys, err := LoadKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedKeys, savedMFA := jwtKeys, mfaRequiredRoles
+	jwtKeys, mfaRequiredRoles = keys, map[Role]bool{}
+	t.Cleanup(func() { jwtKeys, mfaRequiredRoles = savedKeys, savedMFA })
+
+	store := NewMemoryStore()
+	server := httptest.NewServer(NewAPIServer(ServerConfig{}, store).routes())
+	t.Cleanup(server.Close)
+	return &workerAPI{t: t, server: server, store: store}
+}
+
+// createAccount adds an accepted account, remembering its password hash
+func (a *workerAPI) createAccount(email string, role Role) *Worker {
+	a.t.Helper()
+	ctx := context.Background()
+	w := &Worker{FullName: "Test " + string(role), Number: "0550123456", Email: email, Password: testPassword, Position: "mover", IsAccepted: true, Role: role}
+	if err := a.store.CreateWorker(ctx, w); err != nil {
+		a.t.Fatal(err)
+	}
+	stored, err := a.store.GetAccountByID(ctx, w.ID)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	a.secrets = append(a.secrets, stored.Password)
+	return w
+}
+
+// enableTwoFactor enrolls a confirmed authenticator for w, returning a
+// recovery code
+func (a *workerAPI) enableTwoFactor(w *Worker) string {
+	a.t.Helper()
+	ctx := context.Background()
+	secret, err := newTOTPSecret()
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if err := a.store.SaveTOTP(ctx, &TOTP{WorkerID: w.ID, Secret: secret}); err != nil {
+		a.t.Fatal(err)
+	}
+	if err := a.store.ConfirmTOTP(ctx, w.ID, totpStep(time.Now()), hashes); err != nil {
+		a.t.Fatal(err)
+	}
+	a.secrets = append(a.secrets, secret)
+	a.secrets = append(a.secrets, codes...)
+	a.secrets = append(a.secrets, hashes...)
+	return codes[0]
+}
+
+// do sends a request as the session of cookie, if any, checking the
+// response body holds no credentials
+func (a *workerAPI) do(method, path string, cookie *http.Cookie, body any) (*http.Response, string) {
+	a.t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		r = strings.NewReader(string(b))
+	}
+	req, err := http.NewRequest(method, a.server.URL+path, r)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, err := a.server.Client().Do(req)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	got := string(b)
+	if strings.Contains(strings.ToLower(got), "password") {
+		a.t.Errorf("%s %s: body mentions a password: %s", method, path, got)
+	}
+	for _, secret := range a.secrets {
+		if secret != "" && strings.Contains(got, secret) {
+			a.t.Errorf("%s %s: body holds the credential %q: %s", method, path, secret, got)
+		}
+	}
+	return resp, got
+}
+
+// session returns the access token cookie of resp, remembering both tokens
+// of the session and their hashes
+func (a *workerAPI) session(resp *http.Response) *http.Cookie {
+	a.t.Helper()
+	var access *http.Cookie
+	for _, c := range resp.Cookies() {
+		switch c.Name {
+		case accessTokenCookie:
+			access = c
+			a.secrets = append(a.secrets, c.Value)
+		case refreshTokenCookie:
+			a.secrets = append(a.secrets, c.Value, hashToken(c.Value))
+		}
+	}
+	if access == nil {
+		a.t.Fatalf("%s: no session cookie, status %d", resp.Request.URL.Path, resp.StatusCode)
+	}
+	return access
+}
+
+func (a *workerAPI) login(w *Worker) *http.Cookie {
+	a.t.Helper()
+	resp, _ := a.do(http.MethodPost, "/Regestration", nil, &LoginRequest{Email: w.Email, Password: testPassword})
+	return a.session(resp)
+}
+
+func TestWorkerResponsesHoldNoCredentials(t *testing.T) {
+	a := newWorkerAPI(t)
+	ctx := context.Background()
+	if err := bootstrapAdmin(ctx, a.store, "admin@krixo.test", testPassword); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := a.store.GetWorkerByEmail(ctx, "admin@krixo.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := a.store.GetAccountByID(ctx, admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.secrets = append(a.secrets, stored.Password)
+	a.enableTwoFactor(admin)
+
+	dispatcher := a.createAccount("dispatcher@krixo.test", RoleDispatcher)
+	self := a.createAccount("self@krixo.test", RoleWorker)
+	other := a.createAccount("other@krixo.test", RoleWorker)
+	recoveryCode := a.enableTwoFactor(self)
+	otherCode := a.enableTwoFactor(other)
+
+	// Accounts with two-factor authentication log in in two steps
+	twoFactorLogin := func(w *Worker, recoveryCode string) *http.Cookie {
+		t.Helper()
+		_, body := a.do(http.MethodPost, "/Regestration", nil, &LoginRequest{Email: w.Email, Password: testPassword})
+		challenge := new(TwoFactorChallenge)
+		if err := json.Unmarshal([]byte(body), challenge); err != nil || !challenge.TwoFactorRequired {
+			t.Fatalf("login of %s = %s, want a two-factor challenge", w.Email, body)
+		}
+		resp, _ := a.do(http.MethodPost, "/auth/2fa", nil, &TwoFactorLoginRequest{Token: challenge.Token, RecoveryCode: recoveryCode})
+		return a.session(resp)
+	}
+	adminCodes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.store.ReplaceRecoveryCodes(ctx, admin.ID, hashes); err != nil {
+		t.Fatal(err)
+	}
+	a.secrets = append(a.secrets, adminCodes...)
+	a.secrets = append(a.secrets, hashes...)
+
+	callers := []struct {
+		name   string
+		cookie *http.Cookie
+	}{
+		{"admin", twoFactorLogin(admin, adminCodes[0])},
+		{"dispatcher", a.login(dispatcher)},
+		{"self", twoFactorLogin(self, recoveryCode)},
+		{"worker", twoFactorLogin(other, otherCode)},
+	}
+
+	a.do(http.MethodPost, apiV1+"/workers", nil, &CreateWorkerRequest{FullName: "Applicant", Number: "0550123456", Email: "applicant@krixo.test", Position: "mover"})
+	a.do(http.MethodPost, "/CreateWorker", nil, &CreateWorkerRequest{FullName: "Legacy Applicant", Number: "0550123456", Email: "legacy@krixo.test", Position: "mover"})
+
+	for _, caller := range callers {
+		t.Run(caller.name, func(t *testing.T) {
+			for _, path := range []string{
+				apiV1 + "/workers",
+				apiV1 + "/workers/" + admin.ID,
+				apiV1 + "/workers/" + dispatcher.ID,
+				apiV1 + "/workers/" + self.ID,
+				apiV1 + "/workers/" + other.ID,
+				apiV1 + "/workers/available",
+				apiV1 + "/applications",
+				apiV1 + "/me/2fa",
+				"/GetWorkers",
+				"/account/" + admin.ID,
+				"/account/" + self.ID,
+				"/account/" + other.ID,
+			} {
+				resp, body := a.do(http.MethodGet, path, caller.cookie, nil)
+				if resp.StatusCode >= 500 {
+					t.Errorf("GET %s = %d %s", path, resp.StatusCode, body)
+				}
+			}
+
+			name := "Renamed by " + caller.name
+			a.do(http.MethodPatch, apiV1+"/workers/"+self.ID, caller.cookie, &WorkerPatch{FullName: &name})
+			a.do(http.MethodPost, "/UpdateWorker", caller.cookie, map[string]any{"id": self.ID, "fullname": name})
+		})
+	}
+
+	// The checks above mean something only if the calls got workers back
+	resp, body := a.do(http.MethodGet, apiV1+"/workers/"+self.ID, callers[0].cookie, nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, self.Email) {
+		t.Errorf("GET /workers/{id} as admin = %d %s, want the worker", resp.StatusCode, body)
+	}
+}